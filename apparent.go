@@ -0,0 +1,20 @@
+package main
+
+import (
+	"fmt"
+	"math"
+)
+
+// addApparentPower adds the production meter's apparent power (VA) to the
+// reading. Systems without a production CT have no meter to read it from.
+func addApparentPower(r *Reading) error {
+	meter, err := fetchMeterReading("production")
+
+	if err != nil {
+		return fmt.Errorf("failed to read production meter: %w", err)
+	}
+
+	r.Extra["apparent_power"] = math.Round(meter.ApparentPower)
+
+	return nil
+}
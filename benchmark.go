@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+// runBenchmark polls production.json count times using the given number of
+// concurrent workers and prints latency statistics. It never uploads or
+// touches the state file.
+func runBenchmark(count, concurrency int) error {
+	if count < 1 {
+		return fmt.Errorf("benchmark count must be at least 1")
+	}
+
+	if concurrency < 1 {
+		return fmt.Errorf("benchmark concurrency must be at least 1")
+	}
+
+	var (
+		mu        sync.Mutex
+		wg        sync.WaitGroup
+		latencies []time.Duration
+		failures  []error
+	)
+
+	jobs := make(chan int)
+
+	for w := 0; w < concurrency; w++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			for range jobs {
+				var readings EnvoyResponse
+
+				start := time.Now()
+				err := envoyGet("/production.json", &readings)
+				elapsed := time.Since(start)
+
+				mu.Lock()
+				if err != nil {
+					failures = append(failures, err)
+				} else {
+					latencies = append(latencies, elapsed)
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+
+	for i := 0; i < count; i++ {
+		jobs <- i
+	}
+
+	close(jobs)
+	wg.Wait()
+
+	fmt.Printf("Polls:    %d (%d concurrent)\n", count, concurrency)
+	fmt.Printf("Success:  %d\n", len(latencies))
+	fmt.Printf("Failures: %d\n", len(failures))
+
+	for _, err := range failures {
+		fmt.Printf("  %v\n", err)
+	}
+
+	if len(latencies) == 0 {
+		return fmt.Errorf("all %d polls failed", count)
+	}
+
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+
+	var total time.Duration
+	for _, l := range latencies {
+		total += l
+	}
+
+	p95 := latencies[int(math.Ceil(0.95*float64(len(latencies))))-1]
+
+	fmt.Printf("Min:      %v\n", latencies[0].Round(time.Millisecond))
+	fmt.Printf("Avg:      %v\n", (total / time.Duration(len(latencies))).Round(time.Millisecond))
+	fmt.Printf("Max:      %v\n", latencies[len(latencies)-1].Round(time.Millisecond))
+	fmt.Printf("P95:      %v\n", p95.Round(time.Millisecond))
+
+	return nil
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// getSystemServer starts a fake PVOutput API whose getsystem.jsp answers with
+// status and body and counts the requests it receives. Statuses are accepted.
+func getSystemServer(t *testing.T, status int, body string) *int {
+	t.Helper()
+
+	var requests int
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasSuffix(r.URL.Path, "/getsystem.jsp") {
+			w.Write([]byte("OK 200: Added Status"))
+			return
+		}
+
+		requests++
+		w.WriteHeader(status)
+		w.Write([]byte(body))
+	}))
+
+	t.Cleanup(srv.Close)
+
+	opts.PVOutputURL = srv.URL
+	opts.PVOutputVersion = "r2"
+	opts.PVOutputMethod = "POST"
+	outputClient = newHTTPClient(time.Second, 0, nil, false, nil)
+
+	return &requests
+}
+
+func TestFetchSystemSize(t *testing.T) {
+	tests := []struct {
+		status  int
+		body    string
+		want    int
+		wantErr bool
+	}{
+		{http.StatusOK, "My System,4500,2000,18,250,Mono,1,5000,Enphase,N,45.0,No,20100101,-33.9,151.2,5;;0", 4500, false},
+		{http.StatusOK, "My System, 6600 ,2000", 6600, false},
+		{http.StatusOK, "My System", 0, true},
+		{http.StatusOK, "My System,0,2000", 0, true},
+		{http.StatusOK, "My System,4.5kW,2000", 0, true},
+		{http.StatusUnauthorized, "Unauthorized 401: Invalid System ID", 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.body, func(t *testing.T) {
+			useTempState(t)
+			getSystemServer(t, tt.status, tt.body)
+
+			got, err := fetchSystemSize(testTarget().Config)
+
+			if (err != nil) != tt.wantErr || got != tt.want {
+				t.Errorf("fetchSystemSize() = %d, %v, want %d, error %v", got, err, tt.want, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestMaxPower(t *testing.T) {
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.Local)
+	cachedAt := func(age time.Duration) string {
+		return fmt.Sprintf(`{"ratedPower":4000,"ratedPowerFetched":%d}`, now.Add(-age).Unix())
+	}
+
+	tests := []struct {
+		name         string
+		maxPower     int
+		auto         bool
+		state        string
+		status       int
+		want         int
+		wantRequests int
+	}{
+		{"no limit", 0, false, "", http.StatusOK, 0, 0},
+		{"explicit limit wins", 6000, true, "", http.StatusOK, 6000, 0},
+		{"fetched and scaled", 0, true, "", http.StatusOK, 5400, 1},
+		{"cached size", 0, true, cachedAt(24 * time.Hour), http.StatusOK, 4800, 0},
+		{"expired cache", 0, true, cachedAt(8 * 24 * time.Hour), http.StatusOK, 5400, 1},
+		{"fetch failure disables the check", 0, true, "", http.StatusUnauthorized, 0, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useTempState(t)
+			opts.MaxPower = tt.maxPower
+			opts.AutoMaxPower = tt.auto
+
+			if tt.state != "" {
+				writeState(t, tt.state)
+			}
+
+			requests := getSystemServer(t, tt.status, "My System,4500,2000")
+
+			if got := maxPower(testTarget().Config, now); got != tt.want {
+				t.Errorf("maxPower() = %d, want %d", got, tt.want)
+			}
+
+			if *requests != tt.wantRequests {
+				t.Errorf("getsystem.jsp requests = %d, want %d", *requests, tt.wantRequests)
+			}
+
+			if tt.wantRequests > 0 && tt.status == http.StatusOK {
+				// the fetched size is cached for the next run
+				if got := maxPower(testTarget().Config, now.Add(time.Hour)); got != tt.want || *requests != tt.wantRequests {
+					t.Errorf("next run: maxPower() = %d after %d requests", got, *requests)
+				}
+			}
+		})
+	}
+}
+
+func TestRunRejectsPowerAboveRatedSize(t *testing.T) {
+	tests := []struct {
+		watts   int
+		wantErr string
+	}{
+		{5400, ""},
+		{5401, "power of 5401W is above the maximum of 5400W, not sending an implausible reading"},
+	}
+
+	for _, tt := range tests {
+		t.Run(fmt.Sprint(tt.watts, "W"), func(t *testing.T) {
+			useTempState(t)
+			requests := getSystemServer(t, http.StatusOK, "My System,4500,2000")
+
+			envoyServer(t, func(w http.ResponseWriter, r *http.Request) {
+				fmt.Fprintf(w, `{"production":[{"type":"inverters","whLifetime":5000},{"type":"eim","wNow":%d}]}`, tt.watts)
+			})
+
+			opts.Token = "token"
+			opts.ApiKey = "key"
+			opts.SystemID = "1"
+			opts.AutoMaxPower = true
+			opts.TSSuccessCodes = "2xx"
+			opts.SampleCount = 1
+
+			err := run()
+
+			if tt.wantErr == "" && err != nil || tt.wantErr != "" && (err == nil || err.Error() != tt.wantErr) {
+				t.Fatalf("run() = %v, want %q", err, tt.wantErr)
+			}
+
+			if *requests != 1 {
+				t.Errorf("getsystem.jsp requests = %d, want 1", *requests)
+			}
+		})
+	}
+}
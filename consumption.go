@@ -0,0 +1,65 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+// addSelfConsumption adds instantaneous self-consumption and self-sufficiency
+// percentages to the reading.
+//
+// The power used on site from solar is the smaller of production and
+// consumption, so:
+//
+//	self_consumption = min(production, consumption) / production * 100
+//	self_sufficiency = min(production, consumption) / consumption * 100
+//
+// Both are calculated from current power only. Daily figures can't be derived
+// from the whToday totals because energy exported at midday and imported in
+// the evening cancel out; they need separate import/export counters.
+func addSelfConsumption(r *Reading, production, consumption float64) {
+	if production < 0 {
+		production = 0
+	}
+
+	used := math.Min(production, consumption)
+
+	if production > 0 {
+		r.Extra["self_consumption"] = math.Round(used / production * 100)
+	}
+
+	if consumption > 0 {
+		r.Extra["self_sufficiency"] = math.Round(used / consumption * 100)
+	}
+}
+
+// addGridEnergy adds today's grid import and export (Wh) to the reading from
+// the net-consumption meter's lifetime counters. Each counter has its own
+// midnight baseline in the state file.
+//
+// The meter measures from the household's side of the grid connection:
+// positive activePower and actEnergyDlvd are energy delivered to the house
+// (imported), negative activePower and actEnergyRcvd are energy received from
+// the house (exported).
+func addGridEnergy(r *Reading, now time.Time) error {
+	meter, err := fetchMeterReading("net-consumption")
+
+	if err != nil {
+		return fmt.Errorf("failed to read net-consumption meter: %w", err)
+	}
+
+	today, err := loadOrInit(now, map[string]float64{
+		"import": meter.ActEnergyDlvd,
+		"export": meter.ActEnergyRcvd,
+	})
+
+	if err != nil {
+		return err
+	}
+
+	r.Extra["grid_import_today"] = math.Round(today["import"])
+	r.Extra["grid_export_today"] = math.Round(today["export"])
+
+	return nil
+}
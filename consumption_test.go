@@ -0,0 +1,39 @@
+package main
+
+import "testing"
+
+func TestAddSelfConsumption(t *testing.T) {
+	tests := []struct {
+		name                    string
+		production, consumption float64
+		wantConsumption         float64 // -1 when not reported
+		wantSufficiency         float64
+	}{
+		{"exporting", 4000, 1000, 25, 100},
+		{"importing", 1000, 4000, 100, 25},
+		{"balanced", 2500, 2500, 100, 100},
+		{"night", 0, 800, -1, 0},
+		{"negative production at dusk", -15, 800, -1, 0},
+		{"rounded", 3000, 1000, 33, 100},
+		{"nothing running", 0, 0, -1, -1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := Reading{Extra: map[string]float64{}}
+			addSelfConsumption(&r, tt.production, tt.consumption)
+
+			for name, want := range map[string]float64{"self_consumption": tt.wantConsumption, "self_sufficiency": tt.wantSufficiency} {
+				got, ok := r.Extra[name]
+
+				if want == -1 && ok {
+					t.Errorf("%s = %v, want it omitted", name, got)
+				}
+
+				if want != -1 && (!ok || got != want) {
+					t.Errorf("%s = %v (present %v), want %v", name, got, ok, want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"log"
+	"time"
+)
+
+// statusSlot returns the start of the --dedupe-window slot containing date.
+func statusSlot(date time.Time) string {
+	return date.Truncate(opts.DedupeWindow).Format(time.RFC3339)
+}
+
+// slotPosted reports whether a status has already been posted to the PVOutput
+// system in the slot containing date, e.g. by a run shortly before a restart.
+func slotPosted(systemID string, date time.Time) bool {
+	if opts.DedupeWindow <= 0 {
+		return false
+	}
+
+	s, err := loadState()
+
+	if err != nil {
+		log.Printf("Warning: could not load state file, not checking for duplicates: %v", err)
+		return false
+	}
+
+	return s.postedSlot(systemID) == statusSlot(date)
+}
+
+// markSlotPosted records the slot containing date as filled for the system.
+func markSlotPosted(systemID string, date time.Time) {
+	if opts.DedupeWindow <= 0 {
+		return
+	}
+
+	s, err := loadState()
+
+	if err == nil {
+		s.setPostedSlot(systemID, statusSlot(date))
+		err = saveState(s)
+	}
+
+	if err != nil {
+		log.Printf("Warning: could not record posted slot: %v", err)
+	}
+}
+
+// postedSlot returns the last slot posted to the system. The --system-id
+// keeps using LastSlot so existing state files carry over.
+func (s *State) postedSlot(systemID string) string {
+	if systemID == opts.SystemID {
+		return s.LastSlot
+	}
+
+	return s.SystemSlots[systemID]
+}
+
+func (s *State) setPostedSlot(systemID, slot string) {
+	if systemID == opts.SystemID {
+		s.LastSlot = slot
+		return
+	}
+
+	if s.SystemSlots == nil {
+		s.SystemSlots = map[string]string{}
+	}
+
+	s.SystemSlots[systemID] = slot
+}
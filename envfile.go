@@ -0,0 +1,101 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/joho/godotenv"
+)
+
+// loadEnvFile sets the variables in a local env file, or one fetched from an
+// http(s) URL, that aren't already set in the environment. A fetched file is
+// parsed before it is used and cached at --env-file-cache, so that a failed
+// fetch falls back to the last good copy instead of preventing the run.
+func loadEnvFile(path string) error {
+	if !strings.HasPrefix(path, "http://") && !strings.HasPrefix(path, "https://") {
+		return godotenv.Load(path)
+	}
+
+	env, err := fetchEnvFile(path)
+
+	if err != nil {
+		if opts.EnvFileCache == "" {
+			return err
+		}
+
+		data, cacheErr := os.ReadFile(opts.EnvFileCache)
+
+		if cacheErr != nil {
+			return fmt.Errorf("%w, and no cached copy is available: %w", err, cacheErr)
+		}
+
+		log.Printf("Warning: %v, using the cached copy", err)
+
+		if env, err = godotenv.Unmarshal(string(data)); err != nil {
+			return fmt.Errorf("failed to parse cached env file: %w", err)
+		}
+	}
+
+	for key, value := range env {
+		if _, ok := os.LookupEnv(key); !ok {
+			os.Setenv(key, value)
+		}
+	}
+
+	return nil
+}
+
+func fetchEnvFile(target string) (map[string]string, error) {
+	req, err := http.NewRequest("GET", target, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if opts.EnvFileHeader != "" {
+		name, value, ok := strings.Cut(opts.EnvFileHeader, ":")
+		if !ok {
+			return nil, fmt.Errorf("--env-file-header must be in the form Name: value")
+		}
+
+		req.Header.Set(strings.TrimSpace(name), strings.TrimSpace(value))
+	}
+
+	client := &http.Client{Timeout: 10 * time.Second}
+
+	resp, err := client.Do(req)
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch env file: %w", err)
+	}
+
+	defer closeBody(resp.Body)
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to fetch env file: %s", resp.Status)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch env file: %w", err)
+	}
+
+	env, err := godotenv.Unmarshal(string(data))
+
+	if err != nil {
+		return nil, fmt.Errorf("fetched env file is invalid: %w", err)
+	}
+
+	if opts.EnvFileCache != "" {
+		if err := os.WriteFile(opts.EnvFileCache, data, 0o600); err != nil {
+			log.Printf("Warning: could not cache env file: %v", err)
+		}
+	}
+
+	return env, nil
+}
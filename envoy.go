@@ -0,0 +1,324 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// The Envoy response types also model fields go-envoy doesn't use, so that
+// --strict-json only reports fields that are new to the firmware.
+
+type EnvoyResponse struct {
+	Production  []ProductionEntry `json:"production"`
+	Consumption []ProductionEntry `json:"consumption,omitempty"`
+	Storage     []json.RawMessage `json:"storage,omitempty"`
+}
+
+type ProductionEntry struct {
+	Type            string  `json:"type"`
+	MeasurementType string  `json:"measurementType,omitempty"`
+	ActiveCount     int     `json:"activeCount"`
+	WNow            float64 `json:"wNow"`
+	WhLifetime      float64 `json:"whLifetime"`
+	WhToday         float64 `json:"whToday,omitempty"`
+	RMSVoltage      float64 `json:"rmsVoltage,omitempty"`
+
+	ReadingTime      int64             `json:"readingTime"`
+	WhLastSevenDays  float64           `json:"whLastSevenDays,omitempty"`
+	VahToday         float64           `json:"vahToday,omitempty"`
+	VarhLeadToday    float64           `json:"varhLeadToday,omitempty"`
+	VarhLagToday     float64           `json:"varhLagToday,omitempty"`
+	VahLifetime      float64           `json:"vahLifetime,omitempty"`
+	VarhLeadLifetime float64           `json:"varhLeadLifetime,omitempty"`
+	VarhLagLifetime  float64           `json:"varhLagLifetime,omitempty"`
+	RMSCurrent       float64           `json:"rmsCurrent,omitempty"`
+	ReactPwr         float64           `json:"reactPwr,omitempty"`
+	ApprntPwr        float64           `json:"apprntPwr,omitempty"`
+	PwrFactor        float64           `json:"pwrFactor,omitempty"`
+	Lines            []json.RawMessage `json:"lines,omitempty"`
+}
+
+// Meter is an entry from /ivp/meters describing a configured CT meter.
+type Meter struct {
+	EID             int    `json:"eid"`
+	State           string `json:"state"`
+	MeasurementType string `json:"measurementType"`
+	PhaseCount      int    `json:"phaseCount"`
+
+	PhaseMode      string   `json:"phaseMode"`
+	MeteringStatus string   `json:"meteringStatus"`
+	StatusFlags    []string `json:"statusFlags"`
+}
+
+// MeterReading is an entry from /ivp/meters/readings.
+type MeterReading struct {
+	EID         int     `json:"eid"`
+	ActivePower float64 `json:"activePower"`
+
+	// ApparentPower (VA) is only reported by metered systems.
+	ApparentPower float64 `json:"apparentPower"`
+
+	// ActEnergyDlvd and ActEnergyRcvd are lifetime Wh counters. On a
+	// net-consumption meter delivered energy is imported from the grid and
+	// received energy is exported to it.
+	ActEnergyDlvd float64 `json:"actEnergyDlvd"`
+	ActEnergyRcvd float64 `json:"actEnergyRcvd"`
+
+	Timestamp           int64             `json:"timestamp"`
+	ApparentEnergy      float64           `json:"apparentEnergy"`
+	ReactEnergyLagg     float64           `json:"reactEnergyLagg"`
+	ReactEnergyLead     float64           `json:"reactEnergyLead"`
+	InstantaneousDemand float64           `json:"instantaneousDemand"`
+	ReactivePower       float64           `json:"reactivePower"`
+	PwrFactor           float64           `json:"pwrFactor"`
+	Voltage             float64           `json:"voltage"`
+	Current             float64           `json:"current"`
+	Freq                float64           `json:"freq"`
+	Channels            []json.RawMessage `json:"channels"`
+}
+
+// strictJSONPaths are the endpoints checked by --strict-json. Other endpoints
+// return far more than go-envoy models and are always decoded leniently.
+var strictJSONPaths = map[string]bool{
+	"/production.json":     true,
+	"/ivp/meters":          true,
+	"/ivp/meters/readings": true,
+}
+
+// validateHost checks that the Envoy address is a bare IP address or
+// hostname (optionally with a port) so it can be safely used to build URLs.
+func validateHost(host string) error {
+	if host == "" {
+		return fmt.Errorf("address is empty")
+	}
+
+	if strings.Contains(host, "://") {
+		return fmt.Errorf("address must not include a scheme")
+	}
+
+	if strings.ContainsAny(host, "/?#") {
+		return fmt.Errorf("address must not include a path or query")
+	}
+
+	u, err := url.Parse("https://" + host)
+
+	if err != nil || u.Host != host || u.User != nil {
+		return fmt.Errorf("address is not a valid host")
+	}
+
+	name := u.Hostname()
+
+	if net.ParseIP(name) != nil {
+		return nil
+	}
+
+	for _, label := range strings.Split(name, ".") {
+		if label == "" || len(label) > 63 || strings.HasPrefix(label, "-") || strings.HasSuffix(label, "-") {
+			return fmt.Errorf("%q is not a valid hostname", name)
+		}
+
+		// underscores aren't valid in DNS hostnames, but the resolver accepts
+		// them and Docker Compose service names often use them
+		for _, c := range label {
+			if !(c == '-' || c == '_' || c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z') {
+				return fmt.Errorf("%q is not a valid hostname", name)
+			}
+		}
+	}
+
+	return nil
+}
+
+// readToken reads an Envoy token from r, ignoring surrounding whitespace.
+func readToken(r io.Reader) (string, error) {
+	b, err := io.ReadAll(r)
+
+	if err != nil {
+		return "", err
+	}
+
+	token := strings.TrimSpace(string(b))
+
+	if token == "" {
+		return "", fmt.Errorf("no token provided")
+	}
+
+	return token, nil
+}
+
+// envoyProxy returns the proxy function for Envoy requests: none, unless
+// --envoy-proxy names a forward proxy. The --envoy-proxy-user credentials are
+// then put in the proxy URL, so they are sent with the CONNECT request.
+func envoyProxy() (func(*http.Request) (*url.URL, error), error) {
+	if opts.EnvoyProxy == "" {
+		return nil, nil
+	}
+
+	u, err := url.Parse(opts.EnvoyProxy)
+
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("invalid --envoy-proxy %q", opts.EnvoyProxy)
+	}
+
+	if opts.EnvoyProxyUser != "" {
+		u.User = url.UserPassword(opts.EnvoyProxyUser, opts.EnvoyProxyPass)
+	}
+
+	return http.ProxyURL(u), nil
+}
+
+// readTokenFile reads the token from a file such as a mounted secret. It is
+// opened by name on every run, so a secret that is rotated by replacing the
+// file or a symlink to it is picked up without a restart.
+func readTokenFile(path string) (string, error) {
+	f, err := os.Open(path)
+
+	if err != nil {
+		return "", err
+	}
+
+	defer f.Close()
+
+	return readToken(f)
+}
+
+// DecodeError is returned when the Envoy's response could not be decoded,
+// usually because the body was truncated by a connection reset.
+type DecodeError struct {
+	Path string
+	Err  error
+}
+
+func (e *DecodeError) Error() string {
+	return fmt.Sprintf("failed to decode JSON from %s: %v", e.Path, e.Err)
+}
+
+func (e *DecodeError) Unwrap() error {
+	return e.Err
+}
+
+// StatusError is returned when the Envoy responds with a non-200 status.
+type StatusError struct {
+	Path       string
+	StatusCode int
+	Status     string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("unexpected response from %s: %s", e.Path, e.Status)
+}
+
+// envoyGet performs an authenticated GET against the Envoy and decodes the
+// JSON response body into v. Responses that fail to decode are re-fetched up
+// to --decode-retries times.
+func envoyGet(path string, v any) error {
+	for attempt := 0; ; attempt++ {
+		err := envoyGetOnce(path, v)
+
+		var decodeErr *DecodeError
+
+		if err == nil || !errors.As(err, &decodeErr) || attempt >= opts.DecodeRetries {
+			return err
+		}
+
+		log.Printf("Warning: %v, fetching again", err)
+	}
+}
+
+func envoyGetOnce(path string, v any) error {
+	// https://enphase.com/download/iq-gateway-access-using-local-apis-or-local-ui-token-based-authentication-tech-brief
+	req, err := http.NewRequest("GET", fmt.Sprintf("https://%s%s", opts.IpAddress, path), nil)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+
+	req.Header.Set("Accept", "application/json")
+	if firmware.TokenAuth {
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", opts.Token))
+	}
+
+	// the Envoy's own JWT uses Authorization, so credentials for an
+	// authenticating reverse proxy in front of it go in Proxy-Authorization.
+	// A forward proxy gets them when the tunnel is opened instead, as
+	// headers on the request itself would only reach the Envoy.
+	if opts.EnvoyProxyUser != "" && opts.EnvoyProxy == "" {
+		creds := base64.StdEncoding.EncodeToString([]byte(opts.EnvoyProxyUser + ":" + opts.EnvoyProxyPass))
+		req.Header.Set("Proxy-Authorization", "Basic "+creds)
+	}
+
+	resp, err := envoyClient.Do(req)
+
+	if err != nil {
+		return fmt.Errorf("failed to send request: %w", err)
+	}
+
+	defer closeBody(resp.Body)
+
+	if resp.StatusCode != http.StatusOK {
+		return &StatusError{Path: path, StatusCode: resp.StatusCode, Status: resp.Status}
+	}
+
+	dec := json.NewDecoder(resp.Body)
+
+	if opts.StrictJSON && strictJSONPaths[path] {
+		dec.DisallowUnknownFields()
+	}
+
+	if err := dec.Decode(v); err != nil {
+		// encoding/json has no error type for unknown fields; unlike a
+		// truncated response, fetching again won't help
+		if strings.HasPrefix(err.Error(), "json: unknown field") {
+			return fmt.Errorf("%s returned a field go-envoy doesn't model (--strict-json): %w", path, err)
+		}
+
+		return &DecodeError{Path: path, Err: err}
+	}
+
+	return nil
+}
+
+// fetchMeterReading returns the current reading of the enabled meter with the
+// given measurement type (e.g. "production" or "net-consumption").
+func fetchMeterReading(measurementType string) (MeterReading, error) {
+	var meters []Meter
+
+	if err := envoyGet("/ivp/meters", &meters); err != nil {
+		return MeterReading{}, err
+	}
+
+	eid := 0
+
+	for _, m := range meters {
+		if m.MeasurementType == measurementType && m.State == "enabled" {
+			eid = m.EID
+			break
+		}
+	}
+
+	if eid == 0 {
+		return MeterReading{}, fmt.Errorf("no enabled %s meter found", measurementType)
+	}
+
+	var readings []MeterReading
+
+	if err := envoyGet("/ivp/meters/readings", &readings); err != nil {
+		return MeterReading{}, err
+	}
+
+	for _, r := range readings {
+		if r.EID == eid {
+			return r, nil
+		}
+	}
+
+	return MeterReading{}, fmt.Errorf("no reading found for %s meter %d", measurementType, eid)
+}
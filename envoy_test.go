@@ -0,0 +1,330 @@
+package main
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestValidateHost(t *testing.T) {
+	tests := []struct {
+		host    string
+		wantErr bool
+	}{
+		{"192.168.1.50", false},
+		{"envoy.local", false},
+		{"envoy", false},
+		{"192.168.1.50:8443", false},
+		{"[fe80::1]", false},
+		{"", true},
+		{"https://192.168.1.50", true},
+		{"192.168.1.50/production.json", true},
+		{"envoy.local?x=1", true},
+		{"user@envoy.local", true},
+		{"envoy..local", true},
+		{"-envoy.local", true},
+		{"envoy_gw", false},
+		{"envoy_1.local", false},
+		{"envoy local", true},
+	}
+
+	for _, tt := range tests {
+		err := validateHost(tt.host)
+
+		if (err != nil) != tt.wantErr {
+			t.Errorf("validateHost(%q) = %v, want error %v", tt.host, err, tt.wantErr)
+		}
+	}
+}
+
+// forwardProxy starts a proxy that tunnels CONNECT requests and records the
+// Proxy-Authorization header each tunnel was opened with.
+func forwardProxy(t *testing.T) (*httptest.Server, *[]string) {
+	t.Helper()
+
+	var auth []string
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodConnect {
+			http.Error(w, "only CONNECT is supported", http.StatusMethodNotAllowed)
+			return
+		}
+
+		auth = append(auth, r.Header.Get("Proxy-Authorization"))
+
+		upstream, err := net.Dial("tcp", r.Host)
+
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+
+		conn, _, err := w.(http.Hijacker).Hijack()
+
+		if err != nil {
+			upstream.Close()
+			return
+		}
+
+		conn.Write([]byte("HTTP/1.1 200 Connection established\r\n\r\n"))
+
+		go func() {
+			io.Copy(upstream, conn)
+			upstream.Close()
+		}()
+
+		go func() {
+			io.Copy(conn, upstream)
+			conn.Close()
+		}()
+	}))
+
+	t.Cleanup(srv.Close)
+
+	return srv, &auth
+}
+
+func TestEnvoyProxyCredentials(t *testing.T) {
+	tests := []struct {
+		name          string
+		forward       bool
+		wantTunnel    string
+		wantOnRequest string
+	}{
+		// user:pass
+		{"reverse proxy gets them on the request", false, "", "Basic dXNlcjpwYXNz"},
+		{"forward proxy gets them on CONNECT", true, "Basic dXNlcjpwYXNz", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			saved := opts
+			t.Cleanup(func() { opts = saved })
+
+			var onRequest string
+
+			envoy := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				onRequest = r.Header.Get("Proxy-Authorization")
+				w.Write([]byte(`{"production":[]}`))
+			}))
+			defer envoy.Close()
+
+			proxy, tunnels := forwardProxy(t)
+
+			opts = Options{
+				IpAddress:      strings.TrimPrefix(envoy.URL, "https://"),
+				Token:          "token",
+				EnvoyProxyUser: "user",
+				EnvoyProxyPass: "pass",
+			}
+
+			if tt.forward {
+				opts.EnvoyProxy = proxy.URL
+			}
+
+			proxyFunc, err := envoyProxy()
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			envoyClient = newHTTPClient(time.Second, 0, &tls.Config{InsecureSkipVerify: true}, false, proxyFunc)
+
+			var resp EnvoyResponse
+			if err := envoyGetOnce("/production.json", &resp); err != nil {
+				t.Fatal(err)
+			}
+
+			if onRequest != tt.wantOnRequest {
+				t.Errorf("Proxy-Authorization on the request = %q, want %q", onRequest, tt.wantOnRequest)
+			}
+
+			if tt.forward && (len(*tunnels) != 1 || (*tunnels)[0] != tt.wantTunnel) {
+				t.Errorf("CONNECT Proxy-Authorization = %q, want [%q]", *tunnels, tt.wantTunnel)
+			}
+
+			if !tt.forward && len(*tunnels) > 0 {
+				t.Errorf("request went through the forward proxy without --envoy-proxy")
+			}
+		})
+	}
+}
+
+// envoyServer starts a fake Envoy and points the Envoy client at it.
+func envoyServer(t *testing.T, handler http.HandlerFunc) {
+	t.Helper()
+
+	srv := httptest.NewTLSServer(handler)
+	t.Cleanup(srv.Close)
+
+	opts.IpAddress = strings.TrimPrefix(srv.URL, "https://")
+	envoyClient = newHTTPClient(time.Second, 0, &tls.Config{InsecureSkipVerify: true}, false, nil)
+}
+
+func TestEnvoyGetDecodeRetries(t *testing.T) {
+	const production = `{"production":[{"type":"inverters","wNow":1200,"whLifetime":5000}]}`
+
+	tests := []struct {
+		name         string
+		status       int
+		retries      int
+		wantRequests int
+		wantDecode   bool
+		wantErr      bool
+	}{
+		{"truncated once then succeeds", http.StatusOK, 2, 2, false, false},
+		{"truncated with no retries", http.StatusOK, 0, 1, true, true},
+		{"HTTP errors are not retried", http.StatusInternalServerError, 2, 1, false, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			saved := opts
+			t.Cleanup(func() { opts = saved })
+			opts = Options{DecodeRetries: tt.retries}
+
+			requests := 0
+
+			envoyServer(t, func(w http.ResponseWriter, r *http.Request) {
+				requests++
+
+				if tt.status != http.StatusOK {
+					w.WriteHeader(tt.status)
+					return
+				}
+
+				if requests == 1 {
+					// promise the whole body but reset the connection
+					// part way through it
+					w.Header().Set("Content-Length", fmt.Sprint(len(production)))
+					w.Write([]byte(production[:20]))
+					return
+				}
+
+				w.Write([]byte(production))
+			})
+
+			var resp EnvoyResponse
+			err := envoyGet("/production.json", &resp)
+
+			var decodeErr *DecodeError
+
+			if (err != nil) != tt.wantErr || errors.As(err, &decodeErr) != tt.wantDecode {
+				t.Fatalf("err = %v, want error %v (decode error %v)", err, tt.wantErr, tt.wantDecode)
+			}
+
+			if requests != tt.wantRequests {
+				t.Errorf("requests = %d, want %d", requests, tt.wantRequests)
+			}
+
+			if !tt.wantErr && resp.Production[0].WNow != 1200 {
+				t.Errorf("wNow = %v, want 1200", resp.Production[0].WNow)
+			}
+		})
+	}
+}
+
+func TestEnvoyGetChunked(t *testing.T) {
+	for _, debug := range []bool{false, true} {
+		t.Run(fmt.Sprintf("http-debug %v", debug), func(t *testing.T) {
+			saved := opts
+			t.Cleanup(func() { opts = saved })
+			opts = Options{HTTPDebug: debug}
+
+			var chunked bool
+
+			envoyServer(t, func(w http.ResponseWriter, r *http.Request) {
+				// flushing before the handler returns sends the body
+				// chunked, with no Content-Length
+				for _, part := range []string{`{"production":[{"type":"inverters",`, `"wNow":1200,`, `"whLifetime":5000}]}`} {
+					w.Write([]byte(part))
+					w.(http.Flusher).Flush()
+				}
+			})
+
+			wrapped := envoyClient.Transport
+			envoyClient.Transport = roundTripFunc(func(req *http.Request) (*http.Response, error) {
+				resp, err := wrapped.RoundTrip(req)
+
+				if err == nil {
+					chunked = resp.ContentLength == -1 && len(resp.TransferEncoding) > 0 && resp.TransferEncoding[0] == "chunked"
+				}
+
+				return resp, err
+			})
+
+			var resp EnvoyResponse
+			if err := envoyGet("/production.json", &resp); err != nil {
+				t.Fatal(err)
+			}
+
+			if !chunked {
+				t.Errorf("test server didn't send a chunked response")
+			}
+
+			if len(resp.Production) != 1 || resp.Production[0].WNow != 1200 || resp.Production[0].WhLifetime != 5000 {
+				t.Errorf("decoded %+v, want wNow 1200 and whLifetime 5000", resp.Production)
+			}
+		})
+	}
+}
+
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func TestReadTokenFileRotation(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "token")
+
+	// Kubernetes rotates a secret by swapping a symlink to a new directory
+	for i, token := range []string{"first-token", "second-token"} {
+		data := filepath.Join(dir, fmt.Sprintf("data-%d", i))
+
+		if err := os.Mkdir(data, 0o700); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := os.WriteFile(filepath.Join(data, "token"), []byte(token+"\n"), 0o600); err != nil {
+			t.Fatal(err)
+		}
+
+		link := filepath.Join(dir, "link.tmp")
+		if err := os.Symlink(filepath.Join(data, "token"), link); err != nil {
+			t.Skipf("symlinks not supported: %v", err)
+		}
+
+		if err := os.Rename(link, path); err != nil {
+			t.Fatal(err)
+		}
+
+		got, err := readTokenFile(path)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if got != token {
+			t.Errorf("readTokenFile() = %q, want %q", got, token)
+		}
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, "data-1", "token"), []byte("  \n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := readTokenFile(path); err == nil {
+		t.Error("readTokenFile() accepted an empty file")
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+)
+
+// writeEnvTemplate writes a .env template covering every option that can be
+// set from the environment, pre-filled with the current values. Options
+// tagged secret:"true" that are set are replaced with a placeholder. Options
+// without a value are commented out, as go-flags would read an empty variable
+// as an empty value, which list and choice options reject.
+func writeEnvTemplate(w io.Writer, o Options) {
+	v := reflect.ValueOf(o)
+	t := v.Type()
+
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		env := field.Tag.Get("env")
+
+		if env == "" {
+			continue
+		}
+
+		value := fmt.Sprint(v.Field(i).Interface())
+
+		// go-flags splits slice values on env-delim when reading them back
+		if f := v.Field(i); f.Kind() == reflect.Slice {
+			items := make([]string, f.Len())
+
+			for j := range items {
+				items[j] = fmt.Sprint(f.Index(j).Interface())
+			}
+
+			value = strings.Join(items, field.Tag.Get("env-delim"))
+		}
+
+		// an unset secret stays unset, as some are mutually exclusive with
+		// other options
+		if field.Tag.Get("secret") == "true" && value != "" {
+			value = "REPLACE_ME"
+		}
+
+		fmt.Fprintf(w, "# %s\n", field.Tag.Get("description"))
+
+		if field.Tag.Get("required") == "true" {
+			fmt.Fprintf(w, "# (required)\n")
+		}
+
+		if value == "" {
+			fmt.Fprintf(w, "# %s=\n\n", env)
+			continue
+		}
+
+		fmt.Fprintf(w, "%s=%s\n\n", env, value)
+	}
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/jessevdk/go-flags"
+	"github.com/joho/godotenv"
+)
+
+// parseOptions parses args and the environment with the real option parser.
+func parseOptions(t *testing.T, args ...string) Options {
+	t.Helper()
+
+	var o Options
+
+	if _, err := flags.NewParser(&o, flags.Default&^flags.PrintErrors).ParseArgs(args); err != nil {
+		t.Fatalf("parsing %v: %v", args, err)
+	}
+
+	return o
+}
+
+// reloadTemplate writes a template from o and parses it back as --env-file
+// would load it.
+func reloadTemplate(t *testing.T, o Options) Options {
+	t.Helper()
+
+	var b strings.Builder
+	writeEnvTemplate(&b, o)
+
+	env, err := godotenv.Unmarshal(b.String())
+
+	if err != nil {
+		t.Fatalf("template doesn't parse: %v\n%s", err, b.String())
+	}
+
+	for key, value := range env {
+		t.Setenv(key, value)
+	}
+
+	return parseOptions(t)
+}
+
+func TestWriteEnvTemplate(t *testing.T) {
+	var b strings.Builder
+
+	writeEnvTemplate(&b, Options{
+		IpAddress:          "192.168.1.50",
+		Token:              "eyJhbGciOi",
+		InstantaneousPower: []string{"statsd", "influx"},
+	})
+
+	out := b.String()
+
+	for _, want := range []string{
+		"\nIP_ADDRESS=192.168.1.50\n",
+		"\nINSTANTANEOUS_POWER=statsd,influx\n",
+		"\nTOKEN=REPLACE_ME\n",
+		"\n# TS_URL=\n",
+		"\n# HASS_TOKEN=\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("template is missing %q", strings.TrimSpace(want))
+		}
+	}
+
+	if strings.Contains(out, "eyJhbGciOi") {
+		t.Error("template contains the token")
+	}
+}
+
+func TestEnvTemplateRoundTrip(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+	}{
+		{"defaults", []string{"--ip-address", "192.168.1.10"}},
+		{"lists and durations", []string{"--ip-address", "192.168.1.10", "--system-id", "12345", "--instantaneous-power", "statsd", "--instantaneous-power", "influx", "--output-budget", "30s", "--ts-url", "http://ts.local/write"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// only the template may supply options
+			for _, field := range reflect.VisibleFields(reflect.TypeOf(Options{})) {
+				if env := field.Tag.Get("env"); env != "" {
+					t.Setenv(env, "")
+					os.Unsetenv(env)
+				}
+			}
+
+			want := parseOptions(t, tt.args...)
+			got := reloadTemplate(t, want)
+
+			v, g := reflect.ValueOf(want), reflect.ValueOf(got)
+
+			for i := 0; i < v.NumField(); i++ {
+				field := v.Type().Field(i)
+
+				if field.Tag.Get("env") == "" || field.Tag.Get("secret") == "true" {
+					continue
+				}
+
+				if !reflect.DeepEqual(v.Field(i).Interface(), g.Field(i).Interface()) {
+					t.Errorf("%s = %v after reloading, want %v", field.Name, g.Field(i).Interface(), v.Field(i).Interface())
+				}
+			}
+		})
+	}
+}
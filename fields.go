@@ -0,0 +1,71 @@
+package main
+
+import "fmt"
+
+// payloadFields are the default keys used in JSON payloads sent to outputs,
+// which can be renamed with --field-name.
+var payloadFields = []string{"timestamp", "system_id", "power", "energy", "voltage", "grid_connected", "self_consumption", "self_sufficiency", "grid_import_today", "grid_export_today", "apparent_power", "forecast_power", "energy_average", "simulated", "co2_avoided"}
+
+// extraMetricHelp describes each of the optional metrics a reading can carry.
+var extraMetricHelp = map[string]string{
+	"grid_connected":    "Whether the grid-tie relay is closed (1) or open (0).",
+	"self_consumption":  "Percentage of current production consumed on site.",
+	"self_sufficiency":  "Percentage of current consumption supplied by production.",
+	"grid_import_today": "Energy imported from the grid today in watt-hours.",
+	"grid_export_today": "Energy exported to the grid today in watt-hours.",
+	"apparent_power":    "Apparent power of the production meter in volt-amperes.",
+	"forecast_power":    "Forecast production power in watts from the external forecast source.",
+	"energy_average":    "Average daily energy over the last seven days in watt-hours.",
+	"co2_avoided":       "Estimated CO2 emissions avoided by the energy reported, in kilograms.",
+	"simulated":         "Whether the reading was generated by --simulate (1) rather than read from an Envoy.",
+}
+
+// fieldName returns the JSON key to use for one of payloadFields.
+func fieldName(name string) string {
+	if mapped, ok := opts.FieldNames[name]; ok {
+		return mapped
+	}
+
+	return name
+}
+
+// validateFieldNames checks that every renamed field is known and that the
+// resulting keys are non-empty and unique.
+func validateFieldNames(names map[string]string) error {
+	for name, mapped := range names {
+		if !isPayloadField(name) {
+			return fmt.Errorf("unknown field %q", name)
+		}
+
+		if mapped == "" {
+			return fmt.Errorf("field %q cannot be renamed to an empty name", name)
+		}
+	}
+
+	seen := map[string]string{}
+
+	for _, name := range payloadFields {
+		key := name
+		if mapped, ok := names[name]; ok {
+			key = mapped
+		}
+
+		if other, ok := seen[key]; ok {
+			return fmt.Errorf("fields %q and %q would both be named %q", other, name, key)
+		}
+
+		seen[key] = name
+	}
+
+	return nil
+}
+
+func isPayloadField(name string) bool {
+	for _, f := range payloadFields {
+		if f == name {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// firmwareProfile describes behaviour that differs between Envoy firmware
+// generations.
+type firmwareProfile struct {
+	Name string
+
+	// TokenAuth is set when requests must carry a JWT. Firmware before
+	// version 7 predates token authentication.
+	TokenAuth bool
+}
+
+var (
+	legacyFirmware = firmwareProfile{Name: "legacy (pre-7)", TokenAuth: false}
+	tokenFirmware  = firmwareProfile{Name: "token (7+)", TokenAuth: true}
+)
+
+// firmware is the profile used for Envoy requests. It defaults to the current
+// generation until detectFirmware says otherwise.
+var firmware = tokenFirmware
+
+type envoyInfo struct {
+	Device struct {
+		Serial   string `xml:"sn"`
+		Software string `xml:"software"`
+	} `xml:"device"`
+}
+
+// detectFirmware reads the firmware version from the Envoy's unauthenticated
+// info.xml.
+func detectFirmware() (string, error) {
+	resp, err := envoyClient.Get(fmt.Sprintf("https://%s/info.xml", opts.IpAddress))
+
+	if err != nil {
+		return "", fmt.Errorf("failed to fetch info.xml: %w", err)
+	}
+
+	defer closeBody(resp.Body)
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("unexpected response from info.xml: %s", resp.Status)
+	}
+
+	var info envoyInfo
+
+	if err := xml.NewDecoder(resp.Body).Decode(&info); err != nil {
+		return "", fmt.Errorf("failed to decode info.xml: %w", err)
+	}
+
+	if info.Device.Software == "" {
+		return "", fmt.Errorf("info.xml has no software version")
+	}
+
+	return info.Device.Software, nil
+}
+
+// profileFor selects the firmware profile for a version string such as
+// "D7.6.175" or "R3.9.36".
+func profileFor(version string) (firmwareProfile, error) {
+	major, _, _ := strings.Cut(strings.TrimLeft(version, "ABCDEFGHIJKLMNOPQRSTUVWXYZ"), ".")
+
+	n, err := strconv.Atoi(major)
+
+	if err != nil {
+		return tokenFirmware, fmt.Errorf("unrecognised firmware version %q", version)
+	}
+
+	if n < 7 {
+		return legacyFirmware, nil
+	}
+
+	return tokenFirmware, nil
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"testing"
+)
+
+func TestFirmwareSelection(t *testing.T) {
+	tests := []struct {
+		fixture     string
+		wantVersion string
+		wantProfile firmwareProfile
+	}{
+		{"testdata/info_r3.xml", "R3.9.36", legacyFirmware},
+		{"testdata/info_d7.xml", "D7.6.175", tokenFirmware},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.fixture, func(t *testing.T) {
+			saved := opts
+			t.Cleanup(func() { opts = saved })
+			opts = Options{}
+
+			info, err := os.ReadFile(tt.fixture)
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			envoyServer(t, func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path != "/info.xml" {
+					http.NotFound(w, r)
+					return
+				}
+
+				w.Write(info)
+			})
+
+			version, err := detectFirmware()
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if version != tt.wantVersion {
+				t.Errorf("version = %q, want %q", version, tt.wantVersion)
+			}
+
+			profile, err := profileFor(version)
+
+			if err != nil || profile != tt.wantProfile {
+				t.Errorf("profileFor(%q) = %v, %v, want %v", version, profile, err, tt.wantProfile)
+			}
+		})
+	}
+}
+
+func TestProfileForUnrecognised(t *testing.T) {
+	profile, err := profileFor("unknown")
+
+	if err == nil || profile != tokenFirmware {
+		t.Errorf("profileFor(unknown) = %v, %v, want the token profile and an error", profile, err)
+	}
+}
+
+func TestRunTokenByFirmware(t *testing.T) {
+	tests := []struct {
+		fixture string
+		wantErr string
+	}{
+		{"testdata/info_r3.xml", ""},
+		{"testdata/info_d7.xml", "one of --token or --token-file is required"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.fixture, func(t *testing.T) {
+			useTempState(t)
+			t.Cleanup(func() { firmware = tokenFirmware })
+
+			info, err := os.ReadFile(tt.fixture)
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var auth []string
+
+			envoyServer(t, func(w http.ResponseWriter, r *http.Request) {
+				auth = append(auth, r.Header.Get("Authorization"))
+
+				switch r.URL.Path {
+				case "/info.xml":
+					w.Write(info)
+				case "/production.json":
+					w.Write([]byte(`{"production":[{"type":"inverters","wNow":1200,"whLifetime":5000}]}`))
+				default:
+					http.NotFound(w, r)
+				}
+			})
+
+			opts.DetectFirmware = true
+			opts.NoPVOutput = true
+			opts.TSSuccessCodes = "2xx"
+			opts.SampleCount = 1
+
+			err = run()
+
+			if tt.wantErr == "" && err != nil || tt.wantErr != "" && (err == nil || err.Error() != tt.wantErr) {
+				t.Fatalf("run() = %v, want %q", err, tt.wantErr)
+			}
+
+			for _, a := range auth {
+				if a != "" {
+					t.Errorf("Authorization %q sent without a token", a)
+				}
+			}
+		})
+	}
+}
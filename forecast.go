@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// forecastTimeout bounds how long a forecast command or URL may take.
+const forecastTimeout = 10 * time.Second
+
+// addForecast reads the expected power from the configured external source
+// and adds it to the reading so outputs can compare it with the actual power.
+// go-envoy doesn't forecast anything itself.
+func addForecast(r *Reading) error {
+	var raw []byte
+	var err error
+
+	switch {
+	case opts.ForecastFile != "":
+		raw, err = os.ReadFile(opts.ForecastFile)
+	case opts.ForecastCommand != "":
+		ctx, cancel := context.WithTimeout(context.Background(), forecastTimeout)
+		defer cancel()
+
+		raw, err = shellCommand(ctx, opts.ForecastCommand).Output()
+	case opts.ForecastURL != "":
+		raw, err = fetchForecast(opts.ForecastURL)
+	default:
+		return nil
+	}
+
+	if err != nil {
+		return fmt.Errorf("failed to read forecast: %w", err)
+	}
+
+	watts, err := strconv.ParseFloat(strings.TrimSpace(string(raw)), 64)
+
+	if err != nil {
+		return fmt.Errorf("forecast is not a number of watts: %w", err)
+	}
+
+	r.Extra["forecast_power"] = watts
+
+	return nil
+}
+
+func fetchForecast(target string) ([]byte, error) {
+	resp, err := outputClient.Get(target)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer closeBody(resp.Body)
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s returned %s", target, resp.Status)
+	}
+
+	return io.ReadAll(io.LimitReader(resp.Body, 64))
+}
+
+// validateForecastSource checks that at most one forecast source is set.
+func validateForecastSource() error {
+	set := 0
+
+	for _, source := range []string{opts.ForecastFile, opts.ForecastCommand, opts.ForecastURL} {
+		if source != "" {
+			set++
+		}
+	}
+
+	if set > 1 {
+		return fmt.Errorf("only one of --forecast-file, --forecast-command and --forecast-url can be used")
+	}
+
+	return nil
+}
@@ -5,6 +5,5 @@ go 1.24
 require (
 	github.com/jessevdk/go-flags v1.6.1
 	github.com/joho/godotenv v1.5.1
+	golang.org/x/sys v0.21.0
 )
-
-require golang.org/x/sys v0.21.0 // indirect
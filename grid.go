@@ -0,0 +1,82 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+)
+
+// ensembleInventory is an entry from /ivp/ensemble/inventory. Only systems
+// with an Enpower/IQ System Controller report a grid-tie relay.
+type ensembleInventory struct {
+	Type    string `json:"type"`
+	Devices []struct {
+		Serial          string `json:"serial_num"`
+		MainsAdminState string `json:"mains_admin_state"`
+		MainsOperState  string `json:"mains_oper_state"`
+	} `json:"devices"`
+}
+
+// errNoRelay is returned when the system has no grid-tie relay to report on.
+var errNoRelay = errors.New("no grid relay found")
+
+// fetchGridConnected reports whether the grid-tie relay is closed (the system
+// is connected to the grid). It returns errNoRelay on systems without one.
+func fetchGridConnected() (bool, error) {
+	var inventory []ensembleInventory
+
+	if err := envoyGet("/ivp/ensemble/inventory", &inventory); err != nil {
+		var statusErr *StatusError
+
+		if errors.As(err, &statusErr) && statusErr.StatusCode == 404 {
+			return false, errNoRelay
+		}
+
+		return false, err
+	}
+
+	for _, inv := range inventory {
+		if inv.Type != "ENPOWER" {
+			continue
+		}
+
+		for _, d := range inv.Devices {
+			connected := d.MainsOperState == "closed"
+
+			if !connected && d.MainsAdminState == "closed" {
+				log.Printf("Warning: grid relay on %s is open but should be closed, the system is islanded", d.Serial)
+			}
+
+			return connected, nil
+		}
+	}
+
+	return false, errNoRelay
+}
+
+// boolMetric converts a flag into a 0/1 metric value.
+func boolMetric(b bool) float64 {
+	if b {
+		return 1
+	}
+
+	return 0
+}
+
+// addGridStatus adds the grid_connected metric to the reading when the system
+// has a grid-tie relay.
+func addGridStatus(r *Reading) error {
+	connected, err := fetchGridConnected()
+
+	if errors.Is(err, errNoRelay) {
+		return nil
+	}
+
+	if err != nil {
+		return fmt.Errorf("failed to read grid relay status: %w", err)
+	}
+
+	r.Extra["grid_connected"] = boolMetric(connected)
+
+	return nil
+}
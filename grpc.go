@@ -0,0 +1,172 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// grpcMethod is the Record method of the service in proto/reading.proto.
+const grpcMethod = "/goenvoy.v1.ReadingService/Record"
+
+// sendGRPC makes a unary Record call with the reading. gRPC is HTTP/2 with
+// length-prefixed protobuf messages and the status in trailers, and the
+// Reading message is small enough to encode by hand, so this needs neither
+// the gRPC library nor generated code.
+func sendGRPC(r Reading) error {
+	msg := protoReading(r)
+
+	// each message is prefixed with a compressed flag and its length
+	frame := make([]byte, 5, 5+len(msg))
+	binary.BigEndian.PutUint32(frame[1:], uint32(len(msg)))
+	frame = append(frame, msg...)
+
+	req, err := http.NewRequest("POST", strings.TrimRight(opts.GRPCTarget, "/")+grpcMethod, bytes.NewReader(frame))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "application/grpc")
+	req.Header.Set("TE", "trailers")
+
+	if opts.GRPCToken != "" {
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", opts.GRPCToken))
+	}
+
+	resp, err := newGRPCClient(req.URL.Scheme).Do(req)
+
+	if err != nil {
+		return err
+	}
+
+	defer closeBody(resp.Body)
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("gRPC call failed: %s", resp.Status)
+	}
+
+	// the trailers are only available once the body has been read
+	if _, err := io.Copy(io.Discard, resp.Body); err != nil {
+		return fmt.Errorf("gRPC call failed: %w", err)
+	}
+
+	status, message := resp.Trailer.Get("Grpc-Status"), resp.Trailer.Get("Grpc-Message")
+
+	// a call that fails straight away sends its status in the headers
+	if status == "" {
+		status, message = resp.Header.Get("Grpc-Status"), resp.Header.Get("Grpc-Message")
+	}
+
+	if status != "0" {
+		if m, err := url.PathUnescape(message); err == nil {
+			message = m
+		}
+
+		return fmt.Errorf("gRPC call failed: status %s %s", status, message)
+	}
+
+	return nil
+}
+
+// newGRPCClient returns a client that only speaks HTTP/2, over TLS for https
+// targets or unencrypted (h2c) for http targets.
+func newGRPCClient(scheme string) *http.Client {
+	protocols := new(http.Protocols)
+
+	if scheme == "http" {
+		protocols.SetUnencryptedHTTP2(true)
+	} else {
+		protocols.SetHTTP2(true)
+	}
+
+	transport := &http.Transport{
+		Proxy:     http.ProxyFromEnvironment,
+		Protocols: protocols,
+	}
+
+	var rt http.RoundTripper = transport
+
+	if opts.HTTPDebug {
+		rt = &debugTransport{next: transport}
+	}
+
+	return &http.Client{Timeout: 5 * time.Second, Transport: rt}
+}
+
+// validateGRPCTarget checks that --grpc-target is an http or https URL.
+func validateGRPCTarget() error {
+	if opts.GRPCTarget == "" {
+		return nil
+	}
+
+	u, err := url.Parse(opts.GRPCTarget)
+
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("invalid --grpc-target %q, expected e.g. https://ingest:443", opts.GRPCTarget)
+	}
+
+	return nil
+}
+
+// protoReading encodes the reading as the Reading message. Fields with their
+// zero value are left out, as proto3 does.
+func protoReading(r Reading) []byte {
+	var b []byte
+
+	b = appendProtoVarint(b, 1, uint64(r.Date.Unix()))
+	b = appendProtoBytes(b, 2, []byte(opts.SystemID))
+	b = appendProtoVarint(b, 3, uint64(int64(r.Power)))
+	b = appendProtoVarint(b, 4, uint64(int64(r.wattHours())))
+	b = appendProtoVarint(b, 5, uint64(int64(r.Voltage)))
+
+	// a map is encoded as repeated key/value entry messages
+	for _, name := range r.extraNames() {
+		var entry []byte
+		entry = appendProtoBytes(entry, 1, []byte(name))
+		entry = appendProtoDouble(entry, 2, r.Extra[name])
+		b = appendProtoMessage(b, 6, entry)
+	}
+
+	return b
+}
+
+func appendProtoVarint(b []byte, field int, v uint64) []byte {
+	if v == 0 {
+		return b
+	}
+
+	b = binary.AppendUvarint(b, uint64(field)<<3)
+
+	return binary.AppendUvarint(b, v)
+}
+
+func appendProtoDouble(b []byte, field int, v float64) []byte {
+	if v == 0 {
+		return b
+	}
+
+	b = binary.AppendUvarint(b, uint64(field)<<3|1)
+
+	return binary.LittleEndian.AppendUint64(b, math.Float64bits(v))
+}
+
+func appendProtoBytes(b []byte, field int, v []byte) []byte {
+	if len(v) == 0 {
+		return b
+	}
+
+	return appendProtoMessage(b, field, v)
+}
+
+func appendProtoMessage(b []byte, field int, v []byte) []byte {
+	b = binary.AppendUvarint(b, uint64(field)<<3|2)
+	b = binary.AppendUvarint(b, uint64(len(v)))
+
+	return append(b, v...)
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestProtoReading(t *testing.T) {
+	saved := opts
+	t.Cleanup(func() { opts = saved })
+	opts = Options{SystemID: "12"}
+
+	r := Reading{
+		Date:    time.Unix(1700000000, 0),
+		Power:   -3,
+		Energy:  1500,
+		Voltage: 0,
+		Extra:   map[string]float64{"self_consumption": 50},
+	}
+
+	want := []byte{
+		0x08, 0x80, 0xe2, 0xcf, 0xaa, 0x06, // timestamp = 1700000000
+		0x12, 0x02, '1', '2', // system_id = "12"
+		0x18, 0xfd, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x01, // power = -3
+		0x20, 0xdc, 0x0b, // energy = 1500, voltage omitted
+		0x32, 0x1b, // extra entry, 27 bytes
+		0x0a, 0x10, 's', 'e', 'l', 'f', '_', 'c', 'o', 'n', 's', 'u', 'm', 'p', 't', 'i', 'o', 'n',
+		0x11, 0, 0, 0, 0, 0, 0, 0x49, 0x40, // 50.0
+	}
+
+	if got := protoReading(r); !bytes.Equal(got, want) {
+		t.Errorf("protoReading =\n% x\nwant\n% x", got, want)
+	}
+}
+
+func TestSendGRPC(t *testing.T) {
+	tests := []struct {
+		name         string
+		status       string
+		message      string
+		trailersOnly bool
+		wantErr      string
+	}{
+		{"ok", "0", "", false, ""},
+		{"error status", "7", "token%20expired", false, "status 7 token expired"},
+		{"trailers-only error", "16", "unauthenticated", true, "status 16 unauthenticated"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			saved := opts
+			t.Cleanup(func() { opts = saved })
+
+			var received []byte
+
+			srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.ProtoMajor != 2 || r.URL.Path != grpcMethod || r.Header.Get("Content-Type") != "application/grpc" {
+					t.Errorf("unexpected request %s %s %s", r.Proto, r.URL.Path, r.Header.Get("Content-Type"))
+				}
+
+				if r.Header.Get("Authorization") != "Bearer secret" {
+					t.Errorf("Authorization = %q", r.Header.Get("Authorization"))
+				}
+
+				received, _ = io.ReadAll(r.Body)
+
+				w.Header().Set("Content-Type", "application/grpc")
+
+				if tt.trailersOnly {
+					w.Header().Set("Grpc-Status", tt.status)
+					w.Header().Set("Grpc-Message", tt.message)
+					return
+				}
+
+				w.Header().Set("Trailer", "Grpc-Status, Grpc-Message")
+				w.Write([]byte{0, 0, 0, 0, 0}) // empty RecordResponse
+				w.Header().Set("Grpc-Status", tt.status)
+				w.Header().Set("Grpc-Message", tt.message)
+			}))
+
+			srv.Config.Protocols = new(http.Protocols)
+			srv.Config.Protocols.SetUnencryptedHTTP2(true)
+			srv.Start()
+			defer srv.Close()
+
+			opts = Options{GRPCTarget: srv.URL, GRPCToken: "secret"}
+
+			r := Reading{Date: time.Unix(1700000000, 0), Power: 1200, Energy: 3000, Extra: map[string]float64{}}
+			err := sendGRPC(r)
+
+			if tt.wantErr == "" && err != nil {
+				t.Fatal(err)
+			}
+
+			if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Fatalf("err = %v, want it to contain %q", err, tt.wantErr)
+			}
+
+			msg := protoReading(r)
+
+			if len(received) != 5+len(msg) || received[0] != 0 || binary.BigEndian.Uint32(received[1:5]) != uint32(len(msg)) || !bytes.Equal(received[5:], msg) {
+				t.Errorf("received frame % x, want the length-prefixed Reading % x", received, msg)
+			}
+		})
+	}
+}
+
+func TestValidateGRPCTarget(t *testing.T) {
+	saved := opts
+	t.Cleanup(func() { opts = saved })
+
+	for target, wantErr := range map[string]bool{
+		"":                    false,
+		"https://ingest:443":  false,
+		"http://ingest:50051": false,
+		"ingest:443":          true,
+		"grpc://ingest:443":   true,
+	} {
+		opts = Options{GRPCTarget: target}
+
+		if err := validateGRPCTarget(); (err != nil) != wantErr {
+			t.Errorf("validateGRPCTarget(%q) = %v, want error %v", target, err, wantErr)
+		}
+	}
+}
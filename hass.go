@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// hassSensor describes how a metric is presented as a Home Assistant sensor.
+type hassSensor struct {
+	Unit        string
+	DeviceClass string
+	StateClass  string
+}
+
+var hassSensors = map[string]hassSensor{
+	"power":             {Unit: "W", DeviceClass: "power", StateClass: "measurement"},
+	"energy":            {Unit: "Wh", DeviceClass: "energy", StateClass: "total_increasing"},
+	"voltage":           {Unit: "V", DeviceClass: "voltage", StateClass: "measurement"},
+	"self_consumption":  {Unit: "%", StateClass: "measurement"},
+	"self_sufficiency":  {Unit: "%", StateClass: "measurement"},
+	"grid_import_today": {Unit: "Wh", DeviceClass: "energy", StateClass: "total_increasing"},
+	"grid_export_today": {Unit: "Wh", DeviceClass: "energy", StateClass: "total_increasing"},
+	"grid_connected":    {StateClass: "measurement"},
+	"apparent_power":    {Unit: "VA", DeviceClass: "apparent_power", StateClass: "measurement"},
+	"forecast_power":    {Unit: "W", DeviceClass: "power", StateClass: "measurement"},
+	"energy_average":    {Unit: "Wh", DeviceClass: "energy", StateClass: "measurement"},
+	"co2_avoided":       {Unit: "kg", DeviceClass: "weight", StateClass: "total_increasing"},
+}
+
+// postHomeAssistant sets one Home Assistant sensor state per metric through
+// the REST API. Energy sensors use the total_increasing state class so the
+// energy dashboard copes with the daily reset.
+func postHomeAssistant(r Reading) error {
+	states := map[string]float64{
+		"power":  float64(r.Power),
+		"energy": float64(r.wattHours()),
+	}
+
+	if r.Voltage > 0 {
+		states["voltage"] = float64(r.Voltage)
+	}
+
+	for _, name := range r.extraNames() {
+		states[name] = r.Extra[name]
+	}
+
+	var errs []string
+
+	names := make([]string, 0, len(states))
+	for name := range states {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	for _, name := range names {
+		if err := setHassState(opts.HassEntityPrefix+"_"+name, name, states[name]); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("home assistant update failed: %s", strings.Join(errs, "; "))
+	}
+
+	return nil
+}
+
+func setHassState(entity, name string, value float64) error {
+	sensor := hassSensors[name]
+
+	attributes := map[string]string{
+		"friendly_name": "Envoy " + strings.ReplaceAll(name, "_", " "),
+	}
+
+	if sensor.Unit != "" {
+		attributes["unit_of_measurement"] = sensor.Unit
+	}
+
+	if sensor.DeviceClass != "" {
+		attributes["device_class"] = sensor.DeviceClass
+	}
+
+	if sensor.StateClass != "" {
+		attributes["state_class"] = sensor.StateClass
+	}
+
+	body, err := json.Marshal(map[string]any{
+		"state":      fmt.Sprintf("%g", value),
+		"attributes": attributes,
+	})
+
+	if err != nil {
+		return err
+	}
+
+	target := fmt.Sprintf("%s/api/states/%s", strings.TrimRight(opts.HassURL, "/"), entity)
+
+	req, err := http.NewRequest("POST", target, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", opts.HassToken))
+
+	resp, err := outputClient.Do(req)
+
+	if err != nil {
+		return err
+	}
+
+	defer closeBody(resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("%s: %s %s", entity, resp.Status, bytes.TrimSpace(msg))
+	}
+
+	return nil
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"log"
+	"math"
+	"time"
+)
+
+// historyDays is how many complete days the rolling average covers.
+const historyDays = 7
+
+// recordDailyEnergy stores today's energy so far in the state file and
+// returns the average daily energy over up to the last historyDays complete
+// days. days is zero until at least one complete day has been recorded.
+func recordDailyEnergy(now time.Time, energy float64) (avg float64, days int, err error) {
+	s, err := loadState()
+
+	if err != nil {
+		return 0, 0, err
+	}
+
+	if s.DailyEnergy == nil {
+		s.DailyEnergy = map[string]float64{}
+	}
+
+	today := now.Format("2006-01-02")
+	s.DailyEnergy[today] = energy
+
+	var total float64
+
+	for i := 1; i <= historyDays; i++ {
+		if wh, ok := s.DailyEnergy[now.AddDate(0, 0, -i).Format("2006-01-02")]; ok {
+			total += wh
+			days++
+		}
+	}
+
+	// drop days that have fallen out of the window
+	oldest := now.AddDate(0, 0, -historyDays).Format("2006-01-02")
+	for date := range s.DailyEnergy {
+		if date < oldest {
+			delete(s.DailyEnergy, date)
+		}
+	}
+
+	if err := saveState(s); err != nil {
+		return 0, 0, err
+	}
+
+	if days == 0 {
+		return 0, 0, nil
+	}
+
+	return total / float64(days), days, nil
+}
+
+// addEnergyHistory adds the rolling daily average to the reading and logs how
+// today compares with it.
+func addEnergyHistory(r *Reading) error {
+	avg, days, err := recordDailyEnergy(r.Date, r.Energy)
+
+	if err != nil {
+		return err
+	}
+
+	if days == 0 {
+		log.Printf("Today: %.2fkWh so far, no previous days recorded yet", r.Energy/1000)
+		return nil
+	}
+
+	r.Extra["energy_average"] = math.Round(avg)
+
+	log.Printf("Today: %.2fkWh so far, %.0f%% of the %d-day average of %.2fkWh", r.Energy/1000, r.Energy/avg*100, days, avg/1000)
+
+	return nil
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// runHook runs the --on-success or --on-failure command, depending on
+// whether the outputs succeeded, with the reading passed as environment
+// variables. The command's output is copied to the log.
+func runHook(r Reading, outputErr error) {
+	command := opts.OnSuccess
+	if outputErr != nil {
+		command = opts.OnFailure
+	}
+
+	if command == "" {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), opts.HookTimeout)
+	defer cancel()
+
+	cmd := shellCommand(ctx, command)
+	cmd.Env = append(os.Environ(), hookEnv(r, outputErr)...)
+
+	output, err := cmd.CombinedOutput()
+
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+	for scanner.Scan() {
+		log.Printf("Hook: %s", scanner.Text())
+	}
+
+	if ctx.Err() == context.DeadlineExceeded {
+		log.Printf("Warning: hook %q did not finish within %v", command, opts.HookTimeout)
+	} else if err != nil {
+		log.Printf("Warning: hook %q failed: %v", command, err)
+	}
+}
+
+// shellCommand runs command through the platform's shell.
+func shellCommand(ctx context.Context, command string) *exec.Cmd {
+	var cmd *exec.Cmd
+
+	if runtime.GOOS == "windows" {
+		cmd = exec.CommandContext(ctx, "cmd", "/C", command)
+	} else {
+		cmd = exec.CommandContext(ctx, "sh", "-c", command)
+	}
+
+	// don't wait on the output of children that outlive a killed shell
+	cmd.WaitDelay = time.Second
+
+	return cmd
+}
+
+func hookEnv(r Reading, outputErr error) []string {
+	env := []string{
+		fmt.Sprintf("ENVOY_DATE=%s", r.Date.Format(time.RFC3339)),
+		fmt.Sprintf("ENVOY_POWER=%d", r.Power),
+		fmt.Sprintf("ENVOY_ENERGY=%d", r.wattHours()),
+		fmt.Sprintf("ENVOY_VOLTAGE=%d", r.Voltage),
+	}
+
+	for _, name := range r.extraNames() {
+		env = append(env, fmt.Sprintf("ENVOY_%s=%g", strings.ToUpper(name), r.Extra[name]))
+	}
+
+	if outputErr != nil {
+		env = append(env, fmt.Sprintf("ENVOY_ERROR=%s", outputErr))
+	}
+
+	return env
+}
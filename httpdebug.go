@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"log"
+	"net/http"
+	"net/http/httputil"
+	"strings"
+)
+
+// redactedHeaders are never written to the log by --http-debug.
+var redactedHeaders = []string{"Authorization", "Proxy-Authorization", "X-Pvoutput-Apikey"}
+
+// redactedParams are matched case-insensitively against query parameter
+// names, so parameters such as api_key, apikey or access_token are redacted.
+var redactedParams = []string{"key", "token", "secret", "password", "auth"}
+
+// debugTransport logs every request and response, with credentials redacted.
+// The dumps buffer and restore the bodies so decoding is unaffected.
+type debugTransport struct {
+	next http.RoundTripper
+}
+
+func (t *debugTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if dump, err := httputil.DumpRequestOut(req, true); err == nil {
+		log.Printf("HTTP request:\n%s", redactDump(dump))
+	}
+
+	resp, err := t.next.RoundTrip(req)
+
+	if err != nil {
+		log.Printf("HTTP error: %v", err)
+		return nil, err
+	}
+
+	// DumpResponse reads the body to EOF rather than trusting Content-Length,
+	// so chunked responses are dumped whole. If reading fails part way the
+	// body is left half consumed, so fail the request instead of passing it on.
+	dump, err := httputil.DumpResponse(resp, true)
+
+	if err != nil {
+		resp.Body.Close()
+		log.Printf("HTTP error: failed to read response body: %v", err)
+		return nil, err
+	}
+
+	log.Printf("HTTP response:\n%s", redactDump(dump))
+
+	return resp, nil
+}
+
+// redactDump replaces the values of sensitive headers and query parameters in
+// a dumped exchange.
+func redactDump(dump []byte) string {
+	secret := append([]string{}, redactedHeaders...)
+	if opts.TSAuthHeader != "" {
+		secret = append(secret, opts.TSAuthHeader)
+	}
+
+	for name := range opts.OTLPHeaders {
+		secret = append(secret, name)
+	}
+
+	var out strings.Builder
+
+	scanner := bufio.NewScanner(bytes.NewReader(dump))
+	scanner.Buffer(nil, len(dump)+1)
+
+	for first := true; scanner.Scan(); first = false {
+		line := scanner.Text()
+
+		if first {
+			line = redactRequestLine(line)
+		}
+
+		if name, _, ok := strings.Cut(line, ":"); ok {
+			for _, h := range secret {
+				if strings.EqualFold(strings.TrimSpace(name), h) {
+					line = name + ": [redacted]"
+					break
+				}
+			}
+		}
+
+		out.WriteString(line)
+		out.WriteByte('\n')
+	}
+
+	return out.String()
+}
+
+// redactRequestLine replaces the values of secret query parameters in a
+// request line such as "GET /forecast?api_key=abc HTTP/1.1". Response status
+// lines are returned unchanged.
+func redactRequestLine(line string) string {
+	method, rest, ok := strings.Cut(line, " ")
+	if !ok {
+		return line
+	}
+
+	target, proto, ok := strings.Cut(rest, " ")
+	if !ok {
+		return line
+	}
+
+	path, query, ok := strings.Cut(target, "?")
+	if !ok {
+		return line
+	}
+
+	params := strings.Split(query, "&")
+
+	for i, param := range params {
+		name, _, _ := strings.Cut(param, "=")
+
+		for _, secret := range redactedParams {
+			if strings.Contains(strings.ToLower(name), secret) {
+				params[i] = name + "=[redacted]"
+				break
+			}
+		}
+	}
+
+	return method + " " + path + "?" + strings.Join(params, "&") + " " + proto
+}
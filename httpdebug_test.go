@@ -0,0 +1,51 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRedactDump(t *testing.T) {
+	saved := opts
+	t.Cleanup(func() { opts = saved })
+	opts = Options{TSAuthHeader: "X-Api-Token"}
+
+	tests := []struct {
+		name string
+		dump string
+		want string
+	}{
+		{
+			"headers",
+			"POST /write HTTP/1.1\r\nAuthorization: Bearer abc\r\nX-Api-Token: abc\r\nX-Pvoutput-Apikey: abc\r\nContent-Type: text/plain\r\n",
+			"POST /write HTTP/1.1\nAuthorization: [redacted]\nX-Api-Token: [redacted]\nX-Pvoutput-Apikey: [redacted]\nContent-Type: text/plain\n",
+		},
+		{
+			"query parameters",
+			"GET /estimate?lat=51.5&api_key=abc&access_token=def&apikey&days=1 HTTP/1.1\r\nHost: forecast\r\n",
+			"GET /estimate?lat=51.5&api_key=[redacted]&access_token=[redacted]&apikey=[redacted]&days=1 HTTP/1.1\nHost: forecast\n",
+		},
+		{
+			"no query",
+			"GET /production.json HTTP/1.1\r\n",
+			"GET /production.json HTTP/1.1\n",
+		},
+		{
+			"response",
+			"HTTP/1.1 200 OK\r\nContent-Length: 2\r\n\r\n{}",
+			"HTTP/1.1 200 OK\nContent-Length: 2\n\n{}\n",
+		},
+	}
+
+	for _, tt := range tests {
+		got := redactDump([]byte(tt.dump))
+
+		if got != tt.want {
+			t.Errorf("%s: redactDump() =\n%s\nwant\n%s", tt.name, got, tt.want)
+		}
+
+		if strings.Contains(got, "abc") || strings.Contains(got, "def") {
+			t.Errorf("%s: secret left in %q", tt.name, got)
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"log"
+	"math"
+	"time"
+)
+
+const (
+	// minImpliedInterval and maxImpliedInterval bound the time between runs
+	// over which implied power is worth calculating. The lifetime counter
+	// only moves in steps, so short intervals are too coarse, and long gaps
+	// average over too much of the day.
+	minImpliedInterval = 2 * time.Minute
+	maxImpliedInterval = time.Hour
+
+	// minImpliedPower is the power (W) below which differences are ignored,
+	// as around dawn and dusk they are large in relative terms but
+	// meaningless.
+	minImpliedPower = 100
+)
+
+// checkImpliedPower compares the reported power with the average power
+// implied by the change in lifetime energy since the previous run, and warns
+// when they differ by more than --implied-power-tolerance percent. With
+// --implied-power-fallback the implied power is returned in place of a
+// reported power that looks frozen, i.e. is exactly the same as last run's
+// while the lifetime energy says otherwise.
+func checkImpliedPower(now time.Time, whLifetime, watts float64) float64 {
+	s, err := loadState()
+
+	if err != nil {
+		log.Printf("Warning: could not load state file, not checking implied power: %v", err)
+		return watts
+	}
+
+	prevAt := time.Unix(s.PrevLifetimeAt, 0)
+	prevLifetime, prevPower := s.PrevLifetime, s.PrevPower
+
+	s.PrevLifetime = whLifetime
+	s.PrevLifetimeAt = now.Unix()
+	s.PrevPower = watts
+
+	if err := saveState(s); err != nil {
+		log.Printf("Warning: could not save state file: %v", err)
+	}
+
+	elapsed := now.Sub(prevAt)
+
+	if prevLifetime == 0 || elapsed < minImpliedInterval || elapsed > maxImpliedInterval || whLifetime < prevLifetime {
+		return watts
+	}
+
+	implied := (whLifetime - prevLifetime) / elapsed.Hours()
+
+	if max(watts, implied) < minImpliedPower {
+		return watts
+	}
+
+	if math.Abs(watts-implied) <= max(watts, implied)*float64(opts.ImpliedTolerance)/100 {
+		return watts
+	}
+
+	log.Printf("Warning: reported power of %.0fW differs from the %.0fW implied by the lifetime energy over the last %v", watts, implied, elapsed.Round(time.Second))
+
+	if opts.ImpliedFallback && watts == prevPower {
+		log.Printf("Warning: reported power hasn't changed since the last run, using the implied power instead")
+		return implied
+	}
+
+	return watts
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCheckImpliedPower(t *testing.T) {
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.Local)
+
+	// 200Wh in ten minutes implies 1200W
+	previous := func(ago time.Duration, lifetime, power float64) string {
+		return fmt.Sprintf(`{"prevLifetime":%v,"prevLifetimeAt":%d,"prevPower":%v}`, lifetime, now.Add(-ago).Unix(), power)
+	}
+
+	tests := []struct {
+		name        string
+		state       string
+		fallback    bool
+		watts       float64
+		want        float64
+		wantWarning bool
+	}{
+		{"first run", "", false, 500, 500, false},
+		{"interval too short", previous(time.Minute, 9980, 500), false, 500, 500, false},
+		{"interval too long", previous(2*time.Hour, 7600, 500), false, 500, 500, false},
+		{"counter went backwards", previous(10*time.Minute, 10500, 500), false, 500, 500, false},
+		{"below the minimum power", previous(10*time.Minute, 9990, 50), false, 80, 80, false},
+		{"within tolerance", previous(10*time.Minute, 9800, 1000), false, 1100, 1100, false},
+		{"diverges", previous(10*time.Minute, 9800, 1000), false, 500, 500, true},
+		{"diverges, fallback but power changed", previous(10*time.Minute, 9800, 1000), true, 500, 500, true},
+		{"diverges, fallback with frozen power", previous(10*time.Minute, 9800, 500), true, 500, 1200, true},
+		{"frozen but within tolerance", previous(10*time.Minute, 9800, 1150), true, 1150, 1150, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useTempState(t)
+			opts.CheckImpliedPower = true
+			opts.ImpliedTolerance = 20
+			opts.ImpliedFallback = tt.fallback
+
+			if tt.state != "" {
+				writeState(t, tt.state)
+			}
+
+			var logged bytes.Buffer
+			log.SetOutput(&logged)
+			t.Cleanup(func() { log.SetOutput(os.Stderr) })
+
+			if got := checkImpliedPower(now, 10000, tt.watts); got != tt.want {
+				t.Errorf("checkImpliedPower() = %v, want %v", got, tt.want)
+			}
+
+			if warned := strings.Contains(logged.String(), "implied by the lifetime energy"); warned != tt.wantWarning {
+				t.Errorf("warning logged = %v, want %v: %s", warned, tt.wantWarning, logged.String())
+			}
+
+			s, err := loadState()
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if s.PrevLifetime != 10000 || s.PrevLifetimeAt != now.Unix() || s.PrevPower != tt.watts {
+				t.Errorf("state = %v at %d with %vW, want this run's reading", s.PrevLifetime, s.PrevLifetimeAt, s.PrevPower)
+			}
+		})
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// influxEscaper escapes measurement names and tag values in line protocol.
+var influxEscaper = strings.NewReplacer(",", `\,`, " ", `\ `, "=", `\=`)
+
+// writeInflux writes the reading as a single line-protocol point, using the
+// /write endpoint of InfluxDB 1.x or /api/v2/write of InfluxDB 2.x.
+func writeInflux(r Reading) error {
+	var line bytes.Buffer
+
+	line.WriteString(influxEscaper.Replace(opts.InfluxMeasurement))
+
+	if opts.SystemID != "" {
+		fmt.Fprintf(&line, ",system_id=%s", influxEscaper.Replace(opts.SystemID))
+	}
+
+	fmt.Fprintf(&line, " power=%di,energy=%di", r.Power, r.wattHours())
+
+	if r.Voltage > 0 {
+		fmt.Fprintf(&line, ",voltage=%di", r.Voltage)
+	}
+
+	for _, name := range r.extraNames() {
+		fmt.Fprintf(&line, ",%s=%g", name, r.Extra[name])
+	}
+
+	fmt.Fprintf(&line, " %d\n", r.Date.Unix())
+
+	params := url.Values{"precision": {"s"}}
+	base := strings.TrimRight(opts.InfluxURL, "/")
+
+	var target string
+
+	if opts.InfluxVersion == "1" {
+		params.Set("db", opts.InfluxDB)
+		target = base + "/write?" + params.Encode()
+	} else {
+		params.Set("org", opts.InfluxOrg)
+		params.Set("bucket", opts.InfluxBucket)
+		target = base + "/api/v2/write?" + params.Encode()
+	}
+
+	req, err := http.NewRequest("POST", target, &line)
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+
+	if opts.InfluxVersion == "1" {
+		if opts.InfluxUser != "" {
+			req.SetBasicAuth(opts.InfluxUser, opts.InfluxPass)
+		}
+	} else if opts.InfluxToken != "" {
+		req.Header.Set("Authorization", "Token "+opts.InfluxToken)
+	}
+
+	resp, err := outputClient.Do(req)
+
+	if err != nil {
+		return err
+	}
+
+	defer closeBody(resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("influxdb write failed: %s %s", resp.Status, bytes.TrimSpace(msg))
+	}
+
+	return nil
+}
+
+// validateInflux checks that the options needed by the selected InfluxDB
+// version are set.
+func validateInflux() error {
+	if opts.InfluxURL == "" {
+		return nil
+	}
+
+	if opts.InfluxVersion == "1" && opts.InfluxDB == "" {
+		return fmt.Errorf("--influx-db is required with --influx-version 1")
+	}
+
+	if opts.InfluxVersion == "2" && (opts.InfluxOrg == "" || opts.InfluxBucket == "") {
+		return fmt.Errorf("--influx-org and --influx-bucket are required with --influx-version 2")
+	}
+
+	return nil
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestWriteInflux(t *testing.T) {
+	tests := []struct {
+		name      string
+		opts      Options
+		wantPath  string
+		wantQuery string
+		wantAuth  string
+	}{
+		{
+			"v1",
+			Options{InfluxVersion: "1", InfluxDB: "solar", InfluxUser: "envoy", InfluxPass: "secret"},
+			"/write", "db=solar&precision=s", "Basic ZW52b3k6c2VjcmV0",
+		},
+		{
+			"v2",
+			Options{InfluxVersion: "2", InfluxOrg: "home", InfluxBucket: "solar", InfluxToken: "abc"},
+			"/api/v2/write", "bucket=solar&org=home&precision=s", "Token abc",
+		},
+	}
+
+	const wantLine = "envoy,system_id=12 power=1200i,energy=3400i,voltage=241i,self_consumption=55 1700000000\n"
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			saved := opts
+			t.Cleanup(func() { opts = saved })
+
+			var path, query, auth, body string
+
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				path, query, auth = r.URL.Path, r.URL.RawQuery, r.Header.Get("Authorization")
+				b, _ := io.ReadAll(r.Body)
+				body = string(b)
+				w.WriteHeader(http.StatusNoContent)
+			}))
+			defer srv.Close()
+
+			opts = tt.opts
+			opts.InfluxURL = srv.URL
+			opts.InfluxMeasurement = "envoy"
+			opts.SystemID = "12"
+			outputClient = newHTTPClient(time.Second, 0, nil, false, nil)
+
+			r := Reading{
+				Date:    time.Unix(1700000000, 0),
+				Power:   1200,
+				Energy:  3400,
+				Voltage: 241,
+				Extra:   map[string]float64{"self_consumption": 55},
+			}
+
+			if err := writeInflux(r); err != nil {
+				t.Fatal(err)
+			}
+
+			if path != tt.wantPath || query != tt.wantQuery || auth != tt.wantAuth {
+				t.Errorf("request %s?%s auth %q, want %s?%s auth %q", path, query, auth, tt.wantPath, tt.wantQuery, tt.wantAuth)
+			}
+
+			if body != wantLine {
+				t.Errorf("line = %q, want %q", body, wantLine)
+			}
+		})
+	}
+}
+
+func TestValidateInflux(t *testing.T) {
+	saved := opts
+	t.Cleanup(func() { opts = saved })
+
+	tests := []struct {
+		opts    Options
+		wantErr bool
+	}{
+		{Options{}, false},
+		{Options{InfluxURL: "http://influx", InfluxVersion: "1", InfluxDB: "solar"}, false},
+		{Options{InfluxURL: "http://influx", InfluxVersion: "1"}, true},
+		{Options{InfluxURL: "http://influx", InfluxVersion: "2", InfluxOrg: "home", InfluxBucket: "solar"}, false},
+		{Options{InfluxURL: "http://influx", InfluxVersion: "2", InfluxOrg: "home"}, true},
+	}
+
+	for _, tt := range tests {
+		opts = tt.opts
+
+		if err := validateInflux(); (err != nil) != tt.wantErr {
+			t.Errorf("validateInflux(%+v) = %v, want error %v", tt.opts, err, tt.wantErr)
+		}
+	}
+}
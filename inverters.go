@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"text/tabwriter"
+	"time"
+)
+
+// Inverter is an entry from /api/v1/production/inverters.
+type Inverter struct {
+	SerialNumber    string `json:"serialNumber"`
+	LastReportDate  int64  `json:"lastReportDate"`
+	LastReportWatts int    `json:"lastReportWatts"`
+	MaxReportWatts  int    `json:"maxReportWatts"`
+}
+
+// printInverterTable prints each microinverter's latest and peak output,
+// lowest output relative to its peak first so shaded or failing panels are
+// at the top.
+func printInverterTable(now time.Time) error {
+	var inverters []Inverter
+
+	if err := envoyGet("/api/v1/production/inverters", &inverters); err != nil {
+		return fmt.Errorf("failed to read inverters: %w", err)
+	}
+
+	if len(inverters) == 0 {
+		fmt.Println("No inverters reported")
+		return nil
+	}
+
+	sort.SliceStable(inverters, func(i, j int) bool {
+		return peakRatio(inverters[i]) < peakRatio(inverters[j])
+	})
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', tabwriter.AlignRight)
+
+	fmt.Fprintln(w, "Serial\tWatts\tMax watts\t% of max\tMinutes since report\t")
+
+	for _, inv := range inverters {
+		age := now.Sub(time.Unix(inv.LastReportDate, 0))
+
+		fmt.Fprintf(w, "%s\t%d\t%d\t%.0f\t%.0f\t\n",
+			inv.SerialNumber, inv.LastReportWatts, inv.MaxReportWatts, peakRatio(inv)*100, age.Minutes())
+	}
+
+	return w.Flush()
+}
+
+// peakRatio returns the inverter's latest output as a fraction of its peak.
+func peakRatio(inv Inverter) float64 {
+	if inv.MaxReportWatts <= 0 {
+		return 0
+	}
+
+	return float64(inv.LastReportWatts) / float64(inv.MaxReportWatts)
+}
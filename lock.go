@@ -0,0 +1,40 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+// errLocked is returned by tryLock when another process holds the lock.
+var errLocked = errors.New("lock is held by another process")
+
+// acquireLock takes an exclusive lock on path so overlapping runs (e.g. a slow
+// cron run) can't post the same reading twice. If the lock is held it waits up
+// to wait for it to be released. The lock is released when the returned file
+// is closed, and by the OS if the process exits or is killed.
+func acquireLock(path string, wait time.Duration) (*os.File, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0o644)
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to open lock file: %w", err)
+	}
+
+	deadline := time.Now().Add(wait)
+
+	for {
+		err := tryLock(f)
+
+		if err == nil {
+			return f, nil
+		}
+
+		if !errors.Is(err, errLocked) || time.Now().After(deadline) {
+			f.Close()
+			return nil, err
+		}
+
+		time.Sleep(250 * time.Millisecond)
+	}
+}
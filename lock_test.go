@@ -0,0 +1,42 @@
+package main
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestAcquireLockConcurrentStarts(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "go-envoy.lock")
+
+	first, err := acquireLock(path, 0)
+
+	if err != nil {
+		t.Fatalf("first run: %v", err)
+	}
+
+	// a second run that doesn't wait gives up straight away
+	if _, err := acquireLock(path, 0); !errors.Is(err, errLocked) {
+		t.Fatalf("second run without waiting: err = %v, want errLocked", err)
+	}
+
+	// a second run that waits gets the lock once the first finishes
+	go func() {
+		time.Sleep(300 * time.Millisecond)
+		first.Close()
+	}()
+
+	start := time.Now()
+	second, err := acquireLock(path, 5*time.Second)
+
+	if err != nil {
+		t.Fatalf("second run waiting: %v", err)
+	}
+
+	defer second.Close()
+
+	if time.Since(start) < 250*time.Millisecond {
+		t.Errorf("second run got the lock before the first released it")
+	}
+}
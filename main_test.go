@@ -0,0 +1,202 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// countingListener counts the connections accepted by a test server.
+type countingListener struct {
+	net.Listener
+	accepted atomic.Int32
+}
+
+func (l *countingListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+
+	if err == nil {
+		l.accepted.Add(1)
+	}
+
+	return conn, err
+}
+
+func TestHTTPClientConnectionReuse(t *testing.T) {
+	tests := []struct {
+		name      string
+		keepAlive time.Duration
+		want      int32
+	}{
+		{"keep-alive reuses one connection", 90 * time.Second, 1},
+		{"disabled keep-alive connects every time", 0, 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte(`{"production":[]}`))
+			}))
+
+			listener := &countingListener{Listener: srv.Listener}
+			srv.Listener = listener
+			srv.Start()
+			defer srv.Close()
+
+			client := newHTTPClient(time.Second, tt.keepAlive, nil, false, nil)
+
+			for i := 0; i < 3; i++ {
+				resp, err := client.Get(srv.URL)
+
+				if err != nil {
+					t.Fatalf("request %d: %v", i, err)
+				}
+
+				closeBody(resp.Body)
+			}
+
+			if got := listener.accepted.Load(); got != tt.want {
+				t.Errorf("connections = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestShutdownGrace runs shutdownHelper in a child process, which sends
+// itself SIGTERM part way through an upload.
+func TestShutdownGrace(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("SIGTERM can't be sent on Windows")
+	}
+
+	tests := []struct {
+		name       string
+		grace      string
+		upload     string
+		wantExit   int
+		wantOutput string
+	}{
+		{"upload finishes within the grace period", "2s", "200ms", 0, "upload finished"},
+		{"upload outlasts the grace period", "200ms", "5s", 1, "did not finish within --shutdown-grace"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cmd := exec.Command(os.Args[0], "-test.run=^TestShutdownHelper$")
+			cmd.Env = append(os.Environ(), "SHUTDOWN_HELPER=1", "HELPER_GRACE="+tt.grace, "HELPER_UPLOAD="+tt.upload)
+
+			var out bytes.Buffer
+			cmd.Stdout = &out
+			cmd.Stderr = &out
+
+			err := cmd.Run()
+
+			exit := 0
+			var exitErr *exec.ExitError
+			if errors.As(err, &exitErr) {
+				exit = exitErr.ExitCode()
+			} else if err != nil {
+				t.Fatal(err)
+			}
+
+			if exit != tt.wantExit || !strings.Contains(out.String(), tt.wantOutput) {
+				t.Errorf("exit %d, want %d, output:\n%s", exit, tt.wantExit, out.String())
+			}
+		})
+	}
+}
+
+// TestShutdownHelper is the child process for TestShutdownGrace.
+func TestShutdownHelper(t *testing.T) {
+	if os.Getenv("SHUTDOWN_HELPER") != "1" {
+		t.Skip("only run by TestShutdownGrace")
+	}
+
+	grace, _ := time.ParseDuration(os.Getenv("HELPER_GRACE"))
+	upload, _ := time.ParseDuration(os.Getenv("HELPER_UPLOAD"))
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		self, _ := os.FindProcess(os.Getpid())
+		self.Signal(syscall.SIGTERM)
+		time.Sleep(upload)
+	}))
+	defer srv.Close()
+
+	handleShutdown(grace)
+
+	resp, err := newHTTPClient(10*time.Second, 0, nil, false, nil).Post(srv.URL, "text/plain", nil)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	closeBody(resp.Body)
+
+	fmt.Println("upload finished")
+	os.Exit(0)
+}
+
+func TestRunTokenOptions(t *testing.T) {
+	saved := opts
+	t.Cleanup(func() { opts = saved })
+
+	tests := []struct {
+		token, tokenFile string
+		want             string
+	}{
+		{"abc", "/run/secrets/envoy-token", "--token and --token-file are mutually exclusive"},
+		{"", "", "one of --token or --token-file is required"},
+	}
+
+	for _, tt := range tests {
+		opts = Options{IpAddress: "envoy.local", NoPVOutput: true, TSSuccessCodes: "2xx", Token: tt.token, TokenFile: tt.tokenFile}
+
+		if err := run(); err == nil || err.Error() != tt.want {
+			t.Errorf("run() with --token %q --token-file %q = %v, want %q", tt.token, tt.tokenFile, err, tt.want)
+		}
+	}
+}
+
+func TestRunReloadedTokenFileTemplate(t *testing.T) {
+	useTempState(t)
+
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(tokenFile, []byte("eyJhbGciOi\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	envoyServer(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"production":[{"type":"inverters","wNow":1200,"whLifetime":5000}]}`))
+	})
+
+	for _, field := range reflect.VisibleFields(reflect.TypeOf(Options{})) {
+		if env := field.Tag.Get("env"); env != "" {
+			t.Setenv(env, "")
+			os.Unsetenv(env)
+		}
+	}
+
+	configured := parseOptions(t, "--ip-address", opts.IpAddress, "--token-file", tokenFile, "--no-pvoutput")
+	opts = reloadTemplate(t, configured)
+
+	if opts.Token != "" || opts.TokenFile != tokenFile {
+		t.Fatalf("reloaded --token %q --token-file %q", opts.Token, opts.TokenFile)
+	}
+
+	if err := run(); err != nil {
+		t.Errorf("run() with a reloaded template = %v", err)
+	}
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// publishNATS publishes the reading's JSON payload to a NATS subject. It
+// speaks just enough of the NATS text protocol to connect, authenticate and
+// publish, then waits for the server's reply to a PING so a rejected publish
+// is reported rather than lost.
+func publishNATS(r Reading) error {
+	u, err := url.Parse(opts.NATSURL)
+
+	if err != nil {
+		return fmt.Errorf("invalid --nats-url: %w", err)
+	}
+
+	host := u.Host
+	if u.Port() == "" {
+		host = net.JoinHostPort(u.Hostname(), "4222")
+	}
+
+	payload, err := json.Marshal(jsonPayload(r))
+
+	if err != nil {
+		return fmt.Errorf("failed to encode NATS payload: %w", err)
+	}
+
+	conn, err := net.DialTimeout("tcp", host, 5*time.Second)
+
+	if err != nil {
+		return fmt.Errorf("failed to connect to NATS: %w", err)
+	}
+
+	// conn is replaced by the TLS connection when upgrading
+	defer func() { conn.Close() }()
+
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+
+	reader := bufio.NewReader(conn)
+
+	// the server greets every client with its INFO before anything else
+	info, err := reader.ReadString('\n')
+
+	if err != nil {
+		return fmt.Errorf("failed to read NATS server info: %w", err)
+	}
+
+	if !strings.HasPrefix(info, "INFO ") {
+		return fmt.Errorf("unexpected NATS greeting %q", strings.TrimSpace(info))
+	}
+
+	if u.Scheme == "tls" {
+		tlsConn := tls.Client(conn, &tls.Config{ServerName: u.Hostname()})
+
+		if err := tlsConn.Handshake(); err != nil {
+			return fmt.Errorf("NATS TLS handshake failed: %w", err)
+		}
+
+		conn = tlsConn
+		reader = bufio.NewReader(conn)
+	}
+
+	connect := map[string]any{
+		"verbose":  false,
+		"pedantic": false,
+		"name":     "go-envoy",
+		"lang":     "go",
+		"protocol": 0,
+	}
+
+	if u.User != nil {
+		if pass, ok := u.User.Password(); ok {
+			connect["user"] = u.User.Username()
+			connect["pass"] = pass
+		} else {
+			connect["auth_token"] = u.User.Username()
+		}
+	}
+
+	if opts.NATSToken != "" {
+		connect["auth_token"] = opts.NATSToken
+	}
+
+	options, err := json.Marshal(connect)
+
+	if err != nil {
+		return err
+	}
+
+	msg := fmt.Sprintf("CONNECT %s\r\nPUB %s %d\r\n%s\r\nPING\r\n", options, opts.NATSSubject, len(payload), payload)
+
+	if _, err := conn.Write([]byte(msg)); err != nil {
+		return fmt.Errorf("failed to publish to NATS: %w", err)
+	}
+
+	for {
+		line, err := reader.ReadString('\n')
+
+		if err != nil {
+			return fmt.Errorf("failed to publish to NATS: %w", err)
+		}
+
+		line = strings.TrimSpace(line)
+
+		switch {
+		case line == "PONG":
+			return nil
+		case strings.HasPrefix(line, "-ERR"):
+			return fmt.Errorf("NATS publish failed: %s", strings.TrimSpace(strings.TrimPrefix(line, "-ERR")))
+		}
+
+		// skip anything else the server sends, such as its own PING or
+		// a second INFO
+	}
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// otlpAttribute is a key/value attribute in the OTLP JSON encoding.
+type otlpAttribute struct {
+	Key   string `json:"key"`
+	Value struct {
+		StringValue string `json:"stringValue"`
+	} `json:"value"`
+}
+
+type otlpMetric struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	Unit        string `json:"unit,omitempty"`
+	Gauge       struct {
+		DataPoints []otlpDataPoint `json:"dataPoints"`
+	} `json:"gauge"`
+}
+
+type otlpDataPoint struct {
+	TimeUnixNano string  `json:"timeUnixNano"`
+	AsDouble     float64 `json:"asDouble"`
+}
+
+// exportOTLP sends the reading as OTLP gauges to an OpenTelemetry collector
+// over OTLP/HTTP using the JSON encoding, which needs no protobuf or gRPC
+// dependencies.
+func exportOTLP(r Reading) error {
+	timestamp := strconv.FormatInt(r.Date.UnixNano(), 10)
+
+	var metrics []otlpMetric
+
+	gauge := func(name, unit, description string, value float64) {
+		m := otlpMetric{Name: name, Unit: unit, Description: description}
+		m.Gauge.DataPoints = []otlpDataPoint{{TimeUnixNano: timestamp, AsDouble: value}}
+		metrics = append(metrics, m)
+	}
+
+	gauge("envoy.power", "W", "Current production power.", float64(r.Power))
+	gauge("envoy.energy", "Wh", "Energy reported to PVOutput.", float64(r.wattHours()))
+
+	if r.Voltage > 0 {
+		gauge("envoy.voltage", "V", "RMS voltage.", float64(r.Voltage))
+	}
+
+	for _, name := range r.extraNames() {
+		gauge("envoy."+name, "", extraMetricHelp[name], r.Extra[name])
+	}
+
+	resource := []otlpAttribute{otlpAttr("service.name", "go-envoy")}
+
+	if opts.SystemID != "" {
+		resource = append(resource, otlpAttr("pvoutput.system_id", opts.SystemID))
+	}
+
+	if opts.OTLPSiteName != "" {
+		resource = append(resource, otlpAttr("site.name", opts.OTLPSiteName))
+	}
+
+	body, err := json.Marshal(map[string]any{
+		"resourceMetrics": []any{map[string]any{
+			"resource": map[string]any{"attributes": resource},
+			"scopeMetrics": []any{map[string]any{
+				"scope":   map[string]string{"name": "go-envoy"},
+				"metrics": metrics,
+			}},
+		}},
+	})
+
+	if err != nil {
+		return fmt.Errorf("failed to encode OTLP metrics: %w", err)
+	}
+
+	req, err := http.NewRequest("POST", strings.TrimRight(opts.OTLPEndpoint, "/")+"/v1/metrics", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+
+	for name, value := range opts.OTLPHeaders {
+		req.Header.Set(name, value)
+	}
+
+	resp, err := outputClient.Do(req)
+
+	if err != nil {
+		return err
+	}
+
+	defer closeBody(resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("OTLP export failed: %s %s", resp.Status, bytes.TrimSpace(msg))
+	}
+
+	return nil
+}
+
+func otlpAttr(key, value string) otlpAttribute {
+	a := otlpAttribute{Key: key}
+	a.Value.StringValue = value
+	return a
+}
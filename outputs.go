@@ -0,0 +1,157 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math/rand/v2"
+	"runtime/debug"
+	"slices"
+	"time"
+)
+
+// output is one of the optional destinations a reading is sent to after
+// PVOutput.
+type output struct {
+	key     string // used to refer to the output in options
+	name    string
+	enabled bool
+	send    func(Reading) error
+}
+
+// outputDeadline is when the --output-budget for this run runs out. The zero
+// value means there is no budget.
+var outputDeadline time.Time
+
+// startOutputBudget starts the clock on the time allowed for sending the
+// reading to every output.
+func startOutputBudget(budget time.Duration) {
+	if budget > 0 {
+		outputDeadline = time.Now().Add(budget)
+	}
+}
+
+// outputBudgetExceeded reports whether waiting for wait would run past the
+// output budget.
+func outputBudgetExceeded(wait time.Duration) bool {
+	return !outputDeadline.IsZero() && time.Now().Add(wait).After(outputDeadline)
+}
+
+// sendOutputs sends the reading to each enabled output in turn, skipping the
+// rest once the output budget is used up. uploadErr is the result of the
+// PVOutput upload, which the Pushgateway reports.
+func sendOutputs(r Reading, uploadErr error) []error {
+	outputs := []output{
+		{"ts", "time-series", opts.TSURL != "", postTimeSeries},
+		{"statsd", "StatsD", opts.StatsDAddr != "", sendStatsD},
+		{"pushgateway", "Pushgateway", opts.PushgatewayURL != "", func(r Reading) error { return pushMetrics(r, uploadErr) }},
+		{"influx", "InfluxDB", opts.InfluxURL != "", writeInflux},
+		{"sheets", "Google Sheets", opts.SheetsID != "", appendToSheet},
+		{"hass", "Home Assistant", opts.HassURL != "", postHomeAssistant},
+		{"otlp", "OTLP", opts.OTLPEndpoint != "", exportOTLP},
+		{"nats", "NATS", opts.NATSURL != "", publishNATS},
+		{"grpc", "gRPC", opts.GRPCTarget != "", sendGRPC},
+	}
+
+	return sendEach(outputs, r)
+}
+
+// sendEach sends the reading to each output in turn, recovering from a panic
+// in any one of them.
+func sendEach(outputs []output, r Reading) []error {
+	var errs []error
+
+	sent := false
+
+	for _, o := range outputs {
+		if !o.enabled {
+			continue
+		}
+
+		if !outputScheduled(o.key, r.Date) {
+			log.Printf("Skipping %s outside its --output-hours", o.name)
+			continue
+		}
+
+		if sent {
+			staggerOutput()
+		}
+
+		sent = true
+
+		if outputBudgetExceeded(0) {
+			log.Printf("Warning: output budget of %v used up, skipping %s", opts.OutputBudget, o.name)
+			continue
+		}
+
+		if err := o.safeSend(r.forOutput(o.key)); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return errs
+}
+
+// staggerSleep waits between outputs. It is a variable so tests can record
+// the delays.
+var staggerSleep = time.Sleep
+
+// staggerOutput waits a random time up to --report-interval-jitter before an
+// output that follows another, so constrained hardware isn't hit by a burst
+// of writes.
+func staggerOutput() {
+	if opts.ReportJitter > 0 {
+		staggerSleep(rand.N(opts.ReportJitter))
+	}
+}
+
+// safeSend sends the reading, turning a panic in the output into an error
+// so a bug in one output doesn't stop the others or the hook from running.
+func (o output) safeSend(r Reading) error {
+	return recoverSend(o.name, func() error { return o.send(r) })
+}
+
+// recoverSend calls send, turning a panic into an error naming the output.
+// It is shared by the outputs and the PVOutput systems.
+func recoverSend(name string, send func() error) (err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			log.Printf("Error: %s output panicked: %v\n%s", name, p, debug.Stack())
+			err = fmt.Errorf("%s output panicked: %v", name, p)
+		}
+	}()
+
+	return send()
+}
+
+// outputKeys are the names used to refer to outputs in options.
+var outputKeys = []string{"pvoutput", "ts", "statsd", "pushgateway", "influx", "sheets", "hass", "otlp", "nats", "grpc"}
+
+// validateOutputHours checks that --output-hours only names known outputs
+// and valid time windows.
+func validateOutputHours(hours map[string]string) error {
+	for key, window := range hours {
+		if !slices.Contains(outputKeys, key) {
+			return fmt.Errorf("unknown output %q in --output-hours", key)
+		}
+
+		if _, err := parseTimeWindow(window); err != nil {
+			return fmt.Errorf("invalid --output-hours for %s: %w", key, err)
+		}
+	}
+
+	return nil
+}
+
+// outputScheduled reports whether the output is active at t according to
+// --output-hours, in local time. Outputs without hours are always active.
+func outputScheduled(key string, t time.Time) bool {
+	hours, ok := opts.OutputHours[key]
+	if !ok {
+		return true
+	}
+
+	// already checked by validateOutputHours
+	window, _ := parseTimeWindow(hours)
+
+	return window.contains(t)
+}
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// countingServer starts a server that counts its requests, waits for delay
+// and then responds with status.
+func countingServer(t *testing.T, delay time.Duration, status int) (string, *atomic.Int32) {
+	t.Helper()
+
+	var count atomic.Int32
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		count.Add(1)
+		time.Sleep(delay)
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(srv.Close)
+
+	return srv.URL, &count
+}
+
+func TestSendOutputsBudget(t *testing.T) {
+	tests := []struct {
+		name       string
+		budget     time.Duration
+		tsDelay    time.Duration
+		tsStatus   int
+		wantTS     int32
+		wantInflux int32
+	}{
+		{"no budget", 0, 0, http.StatusOK, 1, 1},
+		{"budget used up by a slow output", 100 * time.Millisecond, 150 * time.Millisecond, http.StatusOK, 1, 0},
+		{"retry skipped when the backoff would overrun", 500 * time.Millisecond, 0, http.StatusServiceUnavailable, 1, 1},
+		{"retried within the budget", 0, 0, http.StatusServiceUnavailable, 2, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			saved := opts
+			t.Cleanup(func() {
+				opts = saved
+				outputDeadline = time.Time{}
+			})
+
+			tsURL, ts := countingServer(t, tt.tsDelay, tt.tsStatus)
+			influxURL, influx := countingServer(t, 0, http.StatusNoContent)
+
+			opts = Options{
+				TSURL:          tsURL,
+				TSSuccessCodes: "2xx",
+				TSRetries:      1,
+				InfluxURL:      influxURL,
+				InfluxVersion:  "1",
+				InfluxDB:       "solar",
+				OutputBudget:   tt.budget,
+			}
+			outputClient = newHTTPClient(time.Second, 0, nil, false, nil)
+
+			startOutputBudget(opts.OutputBudget)
+			sendOutputs(Reading{Date: time.Now(), Power: 1200}, nil)
+
+			if ts.Load() != tt.wantTS || influx.Load() != tt.wantInflux {
+				t.Errorf("requests: time-series %d, InfluxDB %d; want %d, %d", ts.Load(), influx.Load(), tt.wantTS, tt.wantInflux)
+			}
+		})
+	}
+}
+
+func TestSendOutputsInstantaneousPower(t *testing.T) {
+	saved := opts
+	t.Cleanup(func() { opts = saved })
+
+	var tsPower float64
+	var influxLine string
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload map[string]any
+		json.NewDecoder(r.Body).Decode(&payload)
+		tsPower, _ = payload["power"].(float64)
+	}))
+	defer ts.Close()
+
+	influx := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		influxLine = string(b)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer influx.Close()
+
+	opts = Options{
+		TSURL:              ts.URL,
+		TSSuccessCodes:     "2xx",
+		InfluxURL:          influx.URL,
+		InfluxVersion:      "1",
+		InfluxDB:           "solar",
+		InfluxMeasurement:  "envoy",
+		InstantaneousPower: []string{"influx"},
+	}
+	outputClient = newHTTPClient(time.Second, 0, nil, false, nil)
+
+	if errs := sendOutputs(Reading{Date: time.Now(), Power: 1000, InstantPower: 1500}, nil); len(errs) > 0 {
+		t.Fatal(errs)
+	}
+
+	if tsPower != 1000 {
+		t.Errorf("time-series power = %v, want the average 1000", tsPower)
+	}
+
+	if !strings.Contains(influxLine, "power=1500i") {
+		t.Errorf("InfluxDB line = %q, want the instantaneous power 1500", influxLine)
+	}
+}
+
+func TestOutputScheduled(t *testing.T) {
+	saved := opts
+	t.Cleanup(func() { opts = saved })
+	opts.OutputHours = map[string]string{
+		"sheets": "06:00-18:00",
+		"statsd": "22:00-06:00",
+		"hass":   "00:00-00:00",
+	}
+
+	at := func(hour, min int) time.Time {
+		return time.Date(2024, 6, 1, hour, min, 0, 0, time.Local)
+	}
+
+	tests := []struct {
+		key  string
+		t    time.Time
+		want bool
+	}{
+		{"sheets", at(5, 59), false},
+		{"sheets", at(6, 0), true},
+		{"sheets", at(17, 59), true},
+		{"sheets", at(18, 0), false},
+		{"statsd", at(21, 59), false},
+		{"statsd", at(22, 0), true},
+		{"statsd", at(0, 0), true},
+		{"statsd", at(5, 59), true},
+		{"statsd", at(6, 0), false},
+		{"hass", at(12, 0), true},
+		{"influx", at(3, 0), true},
+	}
+
+	for _, tt := range tests {
+		if got := outputScheduled(tt.key, tt.t); got != tt.want {
+			t.Errorf("outputScheduled(%s, %s) = %v, want %v", tt.key, tt.t.Format("15:04"), got, tt.want)
+		}
+	}
+}
+
+func TestValidateOutputHours(t *testing.T) {
+	tests := []struct {
+		hours   map[string]string
+		wantErr bool
+	}{
+		{map[string]string{"pvoutput": "05:00-21:00", "grpc": "22:00-06:00"}, false},
+		{map[string]string{"mqtt": "05:00-21:00"}, true},
+		{map[string]string{"ts": "5am-9pm"}, true},
+		{map[string]string{"ts": "25:00-06:00"}, true},
+		{map[string]string{"ts": "05:60-06:00"}, true},
+	}
+
+	for _, tt := range tests {
+		if err := validateOutputHours(tt.hours); (err != nil) != tt.wantErr {
+			t.Errorf("validateOutputHours(%v) = %v, want error %v", tt.hours, err, tt.wantErr)
+		}
+	}
+}
+
+func TestSendEachRecoversFromPanics(t *testing.T) {
+	var sent []string
+
+	outputs := []output{
+		{"a", "first", true, func(Reading) error { panic("boom") }},
+		{"b", "second", true, func(Reading) error {
+			sent = append(sent, "second")
+			return nil
+		}},
+		{"c", "third", true, func(Reading) error {
+			var extra map[string]float64
+			extra["x"] = 1
+			return nil
+		}},
+		{"d", "fourth", true, func(Reading) error {
+			sent = append(sent, "fourth")
+			return nil
+		}},
+	}
+
+	errs := sendEach(outputs, Reading{Date: time.Now()})
+
+	if len(sent) != 2 {
+		t.Errorf("sent to %v, want second and fourth", sent)
+	}
+
+	if len(errs) != 2 || !strings.Contains(errs[0].Error(), "first output panicked: boom") || !strings.Contains(errs[1].Error(), "third output panicked") {
+		t.Errorf("errors = %v", errs)
+	}
+}
+
+func TestRecoverSend(t *testing.T) {
+	var target *pvoutputTarget
+
+	err := recoverSend("PVOutput system 2", func() error { return postStatus(*target, Reading{}, nil) })
+
+	if err == nil || !strings.Contains(err.Error(), "PVOutput system 2 output panicked") {
+		t.Errorf("recoverSend() = %v", err)
+	}
+}
+
+func TestSendEachJitter(t *testing.T) {
+	saved := opts
+	t.Cleanup(func() {
+		opts = saved
+		staggerSleep = time.Sleep
+	})
+
+	var delays []time.Duration
+	staggerSleep = func(d time.Duration) { delays = append(delays, d) }
+
+	send := func(Reading) error { return nil }
+	outputs := []output{
+		{"a", "first", true, send},
+		{"b", "disabled", false, send},
+		{"c", "second", true, send},
+		{"d", "third", true, send},
+	}
+
+	for _, jitter := range []time.Duration{0, 2 * time.Second} {
+		delays = nil
+		opts = Options{ReportJitter: jitter}
+
+		sendEach(outputs, Reading{Date: time.Now()})
+
+		want := 2
+		if jitter == 0 {
+			want = 0
+		}
+
+		if len(delays) != want {
+			t.Errorf("jitter %v: waited %d times, want %d", jitter, len(delays), want)
+		}
+
+		for _, d := range delays {
+			if d < 0 || d >= jitter {
+				t.Errorf("jitter %v: waited %v", jitter, d)
+			}
+		}
+	}
+}
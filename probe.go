@@ -0,0 +1,62 @@
+package main
+
+import "fmt"
+
+// probeCTs reports which current transformers are installed and enabled,
+// based on the eim entries in production.json and the meters in /ivp/meters.
+func probeCTs() error {
+	var readings EnvoyResponse
+
+	if err := envoyGet("/production.json", &readings); err != nil {
+		return fmt.Errorf("failed to read production data: %w", err)
+	}
+
+	fmt.Println("production.json:")
+
+	entries := append(readings.Production, readings.Consumption...)
+	found := false
+
+	for _, e := range entries {
+		if e.Type != "eim" {
+			continue
+		}
+
+		found = true
+		status := "inactive"
+
+		if e.ActiveCount > 0 {
+			status = "active"
+		}
+
+		fmt.Printf("  %-18s %s (activeCount %d)\n", e.MeasurementType, status, e.ActiveCount)
+	}
+
+	if !found {
+		fmt.Println("  no CT (eim) entries reported")
+	}
+
+	fmt.Println("/ivp/meters:")
+
+	var meters []Meter
+
+	if err := envoyGet("/ivp/meters", &meters); err != nil {
+		fmt.Printf("  unavailable: %v\n", err)
+		return nil
+	}
+
+	if len(meters) == 0 {
+		fmt.Println("  no meters configured")
+	}
+
+	for _, m := range meters {
+		fmt.Printf("  %-18s %s (eid %d", m.MeasurementType, m.State, m.EID)
+
+		if m.PhaseCount > 0 {
+			fmt.Printf(", %d phase", m.PhaseCount)
+		}
+
+		fmt.Println(")")
+	}
+
+	return nil
+}
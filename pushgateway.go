@@ -0,0 +1,82 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// pushMetrics replaces this instance's metric group on a Prometheus
+// Pushgateway with the latest reading and PVOutput upload status.
+//
+// The group is deliberately not deleted when the run completes: a cron run
+// exits straight after pushing, and deleting the group would leave nothing for
+// Prometheus to scrape. Each push uses PUT so metrics from the previous run are
+// replaced rather than merged.
+func pushMetrics(r Reading, uploadErr error) error {
+	instance := opts.PushgatewayInstance
+	if instance == "" {
+		instance = opts.SystemID
+	}
+
+	if instance == "" {
+		instance, _ = os.Hostname()
+	}
+
+	target := fmt.Sprintf("%s/metrics/job/%s/instance/%s",
+		strings.TrimRight(opts.PushgatewayURL, "/"),
+		url.PathEscape(opts.PushgatewayJob),
+		url.PathEscape(instance),
+	)
+
+	var body bytes.Buffer
+
+	writeGauge(&body, "envoy_power_watts", "Current production power in watts.", float64(r.Power))
+	writeGauge(&body, "envoy_energy_watt_hours", "Energy reported to PVOutput in watt-hours.", float64(r.wattHours()))
+	writeGauge(&body, "envoy_voltage_volts", "RMS voltage in volts.", float64(r.Voltage))
+
+	for _, name := range r.extraNames() {
+		writeGauge(&body, "envoy_"+name, extraMetricHelp[name], r.Extra[name])
+	}
+
+	if !opts.NoPVOutput {
+		uploadOK := 1
+		if uploadErr != nil {
+			uploadOK = 0
+		}
+
+		writeGauge(&body, "envoy_pvoutput_upload_success", "Whether the last PVOutput upload succeeded.", float64(uploadOK))
+	}
+
+	writeGauge(&body, "envoy_last_run_timestamp_seconds", "Unix time of the last reading.", float64(r.Date.Unix()))
+
+	req, err := http.NewRequest("PUT", target, &body)
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "text/plain; version=0.0.4")
+
+	resp, err := outputClient.Do(req)
+
+	if err != nil {
+		return err
+	}
+
+	defer closeBody(resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("pushgateway push failed: %s %s", resp.Status, bytes.TrimSpace(msg))
+	}
+
+	return nil
+}
+
+func writeGauge(w io.Writer, name, help string, value float64) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s gauge\n%s %g\n", name, help, name, name, value)
+}
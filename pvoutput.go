@@ -0,0 +1,197 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// defaultPVOutputURL is the default --pvoutput-url, the live PVOutput service.
+const defaultPVOutputURL = "https://pvoutput.org"
+
+// validateExtendedFields checks that --pvoutput-extended only maps PVOutput's
+// donor extended parameters (v7 to v12) to known optional values.
+func validateExtendedFields(fields map[string]string) error {
+	for param, name := range fields {
+		switch param {
+		case "v7", "v8", "v9", "v10", "v11", "v12":
+		default:
+			return fmt.Errorf("%q is not an extended parameter (v7-v12)", param)
+		}
+
+		if _, ok := extraMetricHelp[name]; !ok {
+			return fmt.Errorf("unknown value %q for %s", name, param)
+		}
+	}
+
+	return nil
+}
+
+// maxStatusAge is how far in the past PVOutput accepts a status. Donors can
+// post up to 90 days back but the 14 day limit applies to every account.
+const maxStatusAge = 14 * 24 * time.Hour
+
+// validateStatusDate checks that a reading taken at date can be posted to
+// PVOutput at now. Readings from the past are fine (the d and t parameters
+// always describe when the reading was taken, not when it is sent) but
+// PVOutput rejects statuses from the future or older than maxStatusAge.
+func validateStatusDate(date, now time.Time) error {
+	if date.After(now.Add(time.Minute)) {
+		return fmt.Errorf("reading time %s is in the future", date.Format(time.RFC3339))
+	}
+
+	if now.Sub(date) > maxStatusAge {
+		return fmt.Errorf("reading time %s is older than PVOutput accepts", date.Format(time.RFC3339))
+	}
+
+	return nil
+}
+
+func upload(t pvoutputTarget, r Reading) error {
+	if err := validateStatusDate(r.Date, time.Now()); err != nil {
+		return err
+	}
+
+	form := url.Values{}
+	form.Set("d", r.Date.Format("20060102"))
+	form.Set("t", r.Date.Format("15:04"))
+	form.Set("v1", formatEnergy(r.Energy, opts.EnergyDecimals))
+	form.Set("v2", fmt.Sprintf("%d", generationPower(r.Power)))
+	if r.Cumulative {
+		form.Set("c1", "1")
+	}
+	if r.Voltage > 0 {
+		form.Set("v6", fmt.Sprintf("%d", r.Voltage))
+	}
+	for param, name := range t.Extended {
+		if v, ok := r.Extra[name]; ok {
+			form.Set(param, strconv.FormatFloat(v, 'f', -1, 64))
+		}
+	}
+
+	resp, err := pvoutputRequest(t.Config, "addstatus.jsp", form)
+
+	if err != nil {
+		return err
+	}
+
+	defer closeBody(resp.Body)
+
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return &PVOutputError{StatusCode: resp.StatusCode, Message: strings.TrimSpace(string(msg))}
+	}
+
+	return nil
+}
+
+// formatEnergy formats watt-hours for v1 with the given number of decimals.
+// The value is truncated rather than rounded so energy is never overstated,
+// which with no decimals matches the whole watt-hours sent to other outputs.
+func formatEnergy(wh float64, decimals int) string {
+	scale := math.Pow10(decimals)
+	return strconv.FormatFloat(math.Trunc(wh*scale)/scale, 'f', decimals, 64)
+}
+
+// PVOutputError is returned when PVOutput rejects a request. Message holds the
+// response body, e.g. "Bad request 400: Invalid power value".
+type PVOutputError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *PVOutputError) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("%d %s", e.StatusCode, http.StatusText(e.StatusCode))
+	}
+
+	return e.Message
+}
+
+// Detail splits a PVOutput error body of the form "<label> <code>: <detail>"
+// and returns the numeric code and detail. The label ("Bad request",
+// "Forbidden", ...) may be localised but the code is not, so callers should
+// compare codes rather than labels. If the body is not in that form the HTTP
+// status code and whole body are returned.
+func (e *PVOutputError) Detail() (int, string) {
+	label, detail, ok := strings.Cut(e.Message, ":")
+
+	if ok {
+		fields := strings.Fields(label)
+
+		if len(fields) > 0 {
+			if code, err := strconv.Atoi(fields[len(fields)-1]); err == nil {
+				return code, strings.TrimSpace(detail)
+			}
+		}
+	}
+
+	return e.StatusCode, e.Message
+}
+
+// generationPower clamps power to zero. Around dawn and dusk the inverters can
+// draw a few watts, which shows up as slightly negative production that
+// PVOutput rejects as a generation value.
+func generationPower(watts int) int {
+	if watts < 0 {
+		return 0
+	}
+
+	return watts
+}
+
+// validatePVOutputEndpoint checks that the chosen endpoint version supports
+// the parameters that will be sent to it. The r1 API predates the cumulative
+// flag and the donor extended parameters.
+func validatePVOutputEndpoint(version string) error {
+	if version != "r1" {
+		return nil
+	}
+
+	if opts.EnergyMode == "cumulative" {
+		return fmt.Errorf("--energy-mode cumulative requires the r2 endpoint")
+	}
+
+	if len(opts.PVOutputExtended) > 0 {
+		return fmt.Errorf("--pvoutput-extended requires the r2 endpoint")
+	}
+
+	return nil
+}
+
+// pvoutputRequest calls a PVOutput service using the configured endpoint
+// version and HTTP method. Parameters are sent as a form body for POST and as
+// the query string for GET.
+func pvoutputRequest(cfg Config, service string, params url.Values) (*http.Response, error) {
+	endpoint := fmt.Sprintf("%s/service/%s/%s", strings.TrimRight(opts.PVOutputURL, "/"), opts.PVOutputVersion, service)
+
+	var req *http.Request
+	var err error
+
+	if opts.PVOutputMethod == "GET" {
+		req, err = http.NewRequest("GET", endpoint+"?"+params.Encode(), nil)
+	} else {
+		req, err = http.NewRequest("POST", endpoint, strings.NewReader(params.Encode()))
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("X-Pvoutput-Apikey", cfg.APIKey)
+	req.Header.Set("X-Pvoutput-SystemId", cfg.SystemID)
+
+	// keep error details in the language of the logs and issue reports
+	req.Header.Set("Accept-Language", opts.PVOutputLanguage)
+
+	if req.Method == "POST" {
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+
+	return outputClient.Do(req)
+}
@@ -0,0 +1,270 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+// pvoutputServer starts a fake PVOutput API that answers with status and body
+// and records the parameters of each status it receives.
+func pvoutputServer(t *testing.T, status int, body string) *[]url.Values {
+	t.Helper()
+
+	var received []url.Values
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			t.Errorf("invalid form: %v", err)
+		}
+
+		received = append(received, r.Form)
+		w.WriteHeader(status)
+		w.Write([]byte(body))
+	}))
+
+	t.Cleanup(srv.Close)
+
+	opts.PVOutputURL = srv.URL
+	opts.PVOutputVersion = "r2"
+	opts.PVOutputMethod = "POST"
+	outputClient = newHTTPClient(time.Second, 0, nil, false, nil)
+
+	return &received
+}
+
+func testTarget() pvoutputTarget {
+	return pvoutputTarget{Config: Config{APIKey: "key", SystemID: "1"}, Name: "PVOutput"}
+}
+
+func TestUploadEnergyMode(t *testing.T) {
+	tests := []struct {
+		name       string
+		cumulative bool
+		energy     float64
+		wantV1     string
+		wantC1     string
+	}{
+		{"daily", false, 1234, "1234", ""},
+		{"cumulative", true, 12345678, "12345678", "1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useTempState(t)
+			received := pvoutputServer(t, http.StatusOK, "OK 200: Added Status")
+
+			r := Reading{Date: time.Now().Add(-time.Minute), Power: 500, Energy: tt.energy, Cumulative: tt.cumulative}
+
+			if err := upload(testTarget(), r); err != nil {
+				t.Fatal(err)
+			}
+
+			form := (*received)[0]
+
+			if form.Get("v1") != tt.wantV1 || form.Get("c1") != tt.wantC1 {
+				t.Errorf("v1=%q c1=%q, want v1=%q c1=%q", form.Get("v1"), form.Get("c1"), tt.wantV1, tt.wantC1)
+			}
+		})
+	}
+}
+
+func TestGenerationPower(t *testing.T) {
+	tests := []struct {
+		watts, want int
+	}{
+		{-12, 0},
+		{-1, 0},
+		{0, 0},
+		{1, 1},
+		{3500, 3500},
+	}
+
+	for _, tt := range tests {
+		if got := generationPower(tt.watts); got != tt.want {
+			t.Errorf("generationPower(%d) = %d, want %d", tt.watts, got, tt.want)
+		}
+	}
+}
+
+func TestUploadClampsSmallNegativePower(t *testing.T) {
+	useTempState(t)
+	received := pvoutputServer(t, http.StatusOK, "OK 200: Added Status")
+
+	r := Reading{Date: time.Now().Add(-time.Minute), Power: -7, Energy: 0}
+
+	if err := upload(testTarget(), r); err != nil {
+		t.Fatal(err)
+	}
+
+	if v2 := (*received)[0].Get("v2"); v2 != "0" {
+		t.Errorf("v2 = %q, want 0", v2)
+	}
+
+	// other outputs see the true value unless --clamp-negative-power is set
+	if power := jsonPayload(r)["power"]; power != -7 {
+		t.Errorf("JSON power = %v, want -7", power)
+	}
+}
+
+func TestPVOutputErrorDetail(t *testing.T) {
+	tests := []struct {
+		name       string
+		status     int
+		body       string
+		wantCode   int
+		wantDetail string
+	}{
+		{"english", 400, "Bad request 400: Invalid power value", 400, "Invalid power value"},
+		{"localised label", 400, "Mauvaise requête 400: Invalid power value", 400, "Invalid power value"},
+		{"localised forbidden", 403, "Verboten 403: Read only key", 403, "Read only key"},
+		{"no code in body", 401, "Unauthorized", 401, "Unauthorized"},
+		{"empty body", 503, "", 503, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := &PVOutputError{StatusCode: tt.status, Message: tt.body}
+
+			code, detail := err.Detail()
+
+			if code != tt.wantCode || detail != tt.wantDetail {
+				t.Errorf("Detail() = %d, %q, want %d, %q", code, detail, tt.wantCode, tt.wantDetail)
+			}
+		})
+	}
+}
+
+func TestUploadSendsAcceptLanguage(t *testing.T) {
+	useTempState(t)
+
+	var language string
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		language = r.Header.Get("Accept-Language")
+	}))
+	defer srv.Close()
+
+	opts.PVOutputURL = srv.URL
+	opts.PVOutputVersion = "r2"
+	opts.PVOutputLanguage = "en"
+	outputClient = newHTTPClient(time.Second, 0, nil, false, nil)
+
+	if err := upload(testTarget(), Reading{Date: time.Now().Add(-time.Minute)}); err != nil {
+		t.Fatal(err)
+	}
+
+	if language != "en" {
+		t.Errorf("Accept-Language = %q, want en", language)
+	}
+}
+
+func TestFormatEnergy(t *testing.T) {
+	tests := []struct {
+		wh       float64
+		decimals int
+		want     string
+	}{
+		{1234, 0, "1234"},
+		{1234.9, 0, "1234"},
+		{1234.5678, 1, "1234.5"},
+		{1234.5678, 2, "1234.56"},
+		{1234.5678, 3, "1234.567"},
+		{1234, 2, "1234.00"},
+		{0, 3, "0.000"},
+		{0.25, 1, "0.2"},
+	}
+
+	for _, tt := range tests {
+		if got := formatEnergy(tt.wh, tt.decimals); got != tt.want {
+			t.Errorf("formatEnergy(%v, %d) = %q, want %q", tt.wh, tt.decimals, got, tt.want)
+		}
+	}
+}
+
+func TestCO2Avoided(t *testing.T) {
+	tests := []struct {
+		wh     float64
+		factor float64
+		want   string
+	}{
+		{12500, 0.4, "5"},
+		{12345, 0.233, "2.88"},
+		{999, 0.5, "0.5"},
+		{4, 0.4, "0"},
+		{0, 0.4, "0"},
+	}
+
+	for _, tt := range tests {
+		t.Run(fmt.Sprint(tt.wh, "Wh"), func(t *testing.T) {
+			saved := opts
+			t.Cleanup(func() { opts = saved })
+			opts = Options{}
+			forms := pvoutputServer(t, http.StatusOK, "OK 200: Added Status")
+
+			target := testTarget()
+			target.Extended = map[string]string{"v7": "co2_avoided"}
+
+			r := Reading{Date: time.Now(), Energy: tt.wh, Extra: map[string]float64{"co2_avoided": co2Avoided(tt.wh, tt.factor)}}
+
+			if err := upload(target, r); err != nil {
+				t.Fatal(err)
+			}
+
+			if got := (*forms)[0].Get("v7"); got != tt.want {
+				t.Errorf("co2Avoided(%v, %v) posted as %q, want %q", tt.wh, tt.factor, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateStatusDate(t *testing.T) {
+	now := time.Date(2024, 6, 15, 12, 0, 0, 0, time.Local)
+
+	tests := []struct {
+		name    string
+		date    time.Time
+		wantErr bool
+	}{
+		{"now", now, false},
+		{"a few minutes ago", now.Add(-7 * time.Minute), false},
+		{"clock skew within a minute", now.Add(30 * time.Second), false},
+		{"in the future", now.Add(2 * time.Minute), true},
+		{"just inside 14 days", now.Add(-maxStatusAge + time.Minute), false},
+		{"older than 14 days", now.Add(-maxStatusAge - time.Minute), true},
+	}
+
+	for _, tt := range tests {
+		if err := validateStatusDate(tt.date, now); (err != nil) != tt.wantErr {
+			t.Errorf("%s: validateStatusDate() = %v, want error %v", tt.name, err, tt.wantErr)
+		}
+	}
+}
+
+func TestUploadPostsReadingTime(t *testing.T) {
+	saved := opts
+	t.Cleanup(func() { opts = saved })
+	opts = Options{}
+	forms := pvoutputServer(t, http.StatusOK, "OK 200: Added Status")
+
+	// a backfilled reading from an earlier slot, posted after a later one
+	later := time.Now().Add(-10 * time.Minute).Truncate(time.Minute)
+	earlier := later.Add(-20 * time.Minute)
+
+	for _, date := range []time.Time{later, earlier} {
+		if err := upload(testTarget(), Reading{Date: date, Power: 1200}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for i, date := range []time.Time{later, earlier} {
+		got := (*forms)[i]
+
+		if got.Get("d") != date.Format("20060102") || got.Get("t") != date.Format("15:04") {
+			t.Errorf("status %d posted for %s %s, want %s", i, got.Get("d"), got.Get("t"), date.Format("20060102 15:04"))
+		}
+	}
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// har is the subset of the HTTP Archive format needed to replay responses.
+type har struct {
+	Log struct {
+		Entries []struct {
+			Request struct {
+				URL string `json:"url"`
+			} `json:"request"`
+			Response struct {
+				Status     int    `json:"status"`
+				StatusText string `json:"statusText"`
+				Headers    []struct {
+					Name  string `json:"name"`
+					Value string `json:"value"`
+				} `json:"headers"`
+				Content struct {
+					Text     string `json:"text"`
+					Encoding string `json:"encoding"`
+				} `json:"content"`
+			} `json:"response"`
+		} `json:"entries"`
+	} `json:"log"`
+}
+
+// replayResponse is a captured response, stored without its body reader so
+// it can be served any number of times.
+type replayResponse struct {
+	StatusCode int
+	Status     string
+	Header     http.Header
+	Body       []byte
+}
+
+// replayTransport answers Envoy requests from a capture instead of the
+// network. Responses from a HAR file are matched on the request path; a raw
+// HTTP response is returned for every request.
+type replayTransport struct {
+	byPath map[string]replayResponse
+	any    *replayResponse
+}
+
+// validateReplay stops --replay posting another system's capture to a real
+// PVOutput system.
+func validateReplay() error {
+	if opts.Replay == "" || opts.NoPVOutput {
+		return nil
+	}
+
+	if strings.TrimSuffix(opts.PVOutputURL, "/") == defaultPVOutputURL {
+		return fmt.Errorf("--replay needs --no-pvoutput or a test --pvoutput-url, so the captured reading isn't posted to %s", defaultPVOutputURL)
+	}
+
+	return nil
+}
+
+// newReplayTransport loads a HAR file, or failing that a raw HTTP response
+// as saved by curl -i or a proxy.
+func newReplayTransport(path string) (*replayTransport, error) {
+	data, err := os.ReadFile(path)
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to read capture: %w", err)
+	}
+
+	var h har
+	if json.Unmarshal(data, &h) == nil && len(h.Log.Entries) > 0 {
+		return harTransport(h)
+	}
+
+	// curl -i prints HTTP/2 and HTTP/3 status lines, which ReadResponse
+	// doesn't accept, but the rest of the response parses the same
+	for _, proto := range []string{"HTTP/2 ", "HTTP/3 "} {
+		if bytes.HasPrefix(data, []byte(proto)) {
+			data = append([]byte("HTTP/1.1 "), data[len(proto):]...)
+		}
+	}
+
+	resp, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(data)), nil)
+
+	if err != nil {
+		return nil, fmt.Errorf("capture is neither a HAR file nor an HTTP response: %w", err)
+	}
+
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to read captured body: %w", err)
+	}
+
+	return &replayTransport{
+		any: &replayResponse{StatusCode: resp.StatusCode, Status: resp.Status, Header: resp.Header, Body: body},
+	}, nil
+}
+
+func harTransport(h har) (*replayTransport, error) {
+	t := &replayTransport{byPath: map[string]replayResponse{}}
+
+	for _, e := range h.Log.Entries {
+		u, err := url.Parse(e.Request.URL)
+
+		if err != nil {
+			return nil, fmt.Errorf("invalid URL %q in capture: %w", e.Request.URL, err)
+		}
+
+		// the first response for a path wins, like the first poll of a run
+		if _, ok := t.byPath[u.Path]; ok {
+			continue
+		}
+
+		body := []byte(e.Response.Content.Text)
+
+		if e.Response.Content.Encoding == "base64" {
+			if body, err = base64.StdEncoding.DecodeString(e.Response.Content.Text); err != nil {
+				return nil, fmt.Errorf("invalid base64 body for %s in capture: %w", u.Path, err)
+			}
+		}
+
+		header := http.Header{}
+		for _, hdr := range e.Response.Headers {
+			header.Add(hdr.Name, hdr.Value)
+		}
+
+		// the body in a HAR file has already been decoded and de-chunked
+		header.Del("Content-Encoding")
+		header.Del("Transfer-Encoding")
+		header.Del("Content-Length")
+
+		t.byPath[u.Path] = replayResponse{
+			StatusCode: e.Response.Status,
+			Status:     strings.TrimSpace(fmt.Sprintf("%d %s", e.Response.Status, e.Response.StatusText)),
+			Header:     header,
+			Body:       body,
+		}
+	}
+
+	return t, nil
+}
+
+func (t *replayTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	captured := t.any
+
+	if captured == nil {
+		r, ok := t.byPath[req.URL.Path]
+
+		if !ok {
+			r = replayResponse{StatusCode: http.StatusNotFound, Status: "404 Not Found (not in capture)", Header: http.Header{}}
+		}
+
+		captured = &r
+	}
+
+	return &http.Response{
+		StatusCode:    captured.StatusCode,
+		Status:        captured.Status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        captured.Header.Clone(),
+		Body:          io.NopCloser(bytes.NewReader(captured.Body)),
+		ContentLength: int64(len(captured.Body)),
+		Request:       req,
+	}, nil
+}
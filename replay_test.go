@@ -0,0 +1,67 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestValidateReplay(t *testing.T) {
+	saved := opts
+	t.Cleanup(func() { opts = saved })
+
+	tests := []struct {
+		name    string
+		opts    Options
+		wantErr bool
+	}{
+		{"not replaying", Options{PVOutputURL: defaultPVOutputURL}, false},
+		{"live PVOutput", Options{Replay: "capture.har", PVOutputURL: defaultPVOutputURL}, true},
+		{"no PVOutput", Options{Replay: "capture.har", PVOutputURL: defaultPVOutputURL, NoPVOutput: true}, false},
+		{"test server", Options{Replay: "capture.har", PVOutputURL: "http://localhost:8080"}, false},
+	}
+
+	for _, tt := range tests {
+		opts = tt.opts
+
+		if err := validateReplay(); (err != nil) != tt.wantErr {
+			t.Errorf("%s: validateReplay() = %v, want error %v", tt.name, err, tt.wantErr)
+		}
+	}
+}
+
+func TestRunReplayLeavesLiveState(t *testing.T) {
+	useTempState(t)
+
+	live := []byte(`{"date":"2024-06-01","baselines":{"energy":8000},"lifetimes":{"energy":10000}}`)
+	writeState(t, string(live))
+	livePath := statePath
+
+	capture := filepath.Join(t.TempDir(), "production.http")
+	response := "HTTP/1.1 200 OK\r\nContent-Type: application/json\r\n\r\n" +
+		`{"production":[{"type":"inverters","wNow":3200,"whLifetime":50000}]}`
+
+	if err := os.WriteFile(capture, []byte(response), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	opts.IpAddress = "envoy.local"
+	opts.Token = "anything"
+	opts.Replay = capture
+	opts.NoPVOutput = true
+	opts.TSSuccessCodes = "2xx"
+	opts.SampleCount = 1
+
+	if err := run(); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, _ := os.ReadFile(livePath); !bytes.Equal(got, live) {
+		t.Errorf("live state file changed to %s", got)
+	}
+
+	if _, err := os.Stat(filepath.Join(filepath.Dir(livePath), "state-replay.json")); err != nil {
+		t.Errorf("replay state file wasn't written: %v", err)
+	}
+}
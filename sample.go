@@ -0,0 +1,168 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"slices"
+	"time"
+)
+
+// Sample is a single poll of the Envoy.
+type Sample struct {
+	Time       time.Time
+	Power      float64 // watts
+	Voltage    float64 // volts
+	WhLifetime float64 // watt-hours
+
+	// Consumption is the total household consumption in watts, only
+	// available when consumption CTs are installed.
+	Consumption    float64
+	HasConsumption bool
+}
+
+// takeSample polls the Envoy once, reading power from the configured
+// --power-source.
+func takeSample() (Sample, error) {
+	var readings EnvoyResponse
+
+	if err := envoyGet("/production.json", &readings); err != nil {
+		return Sample{}, fmt.Errorf("failed to read production data: %w", err)
+	}
+
+	s := Sample{Time: time.Now()}
+
+	var productionVoltage, consumptionVoltage float64
+
+	for _, p := range readings.Production {
+		if p.Type == "inverters" {
+			s.WhLifetime = p.WhLifetime
+		} else if p.Type == "eim" {
+			s.Power = p.WNow
+			productionVoltage = p.RMSVoltage
+		}
+	}
+
+	for _, c := range readings.Consumption {
+		if c.MeasurementType == "total-consumption" {
+			s.Consumption = c.WNow
+			s.HasConsumption = true
+			consumptionVoltage = c.RMSVoltage
+		}
+	}
+
+	s.Voltage = selectVoltage(productionVoltage, consumptionVoltage)
+
+	if opts.PowerSource == "meter" {
+		meter, err := fetchMeterReading("production")
+
+		if err != nil {
+			return Sample{}, fmt.Errorf("failed to read production meter: %w", err)
+		}
+
+		s.Power = meter.ActivePower
+	}
+
+	return s, nil
+}
+
+// selectVoltage picks the voltage to report according to
+// --report-voltage-from. A meter that isn't installed reports zero, so avg
+// falls back to whichever meter has a reading.
+func selectVoltage(production, consumption float64) float64 {
+	switch opts.ReportVoltageFrom {
+	case "consumption":
+		return consumption
+	case "avg":
+		if production == 0 || consumption == 0 {
+			return production + consumption
+		}
+
+		return (production + consumption) / 2
+	default:
+		return production
+	}
+}
+
+// takeSamples polls the Envoy count times, interval apart. Failed polls are
+// logged and skipped; an error is only returned if every poll failed.
+func takeSamples(count int, interval time.Duration) ([]Sample, error) {
+	if count < 1 || count > 30 {
+		return nil, fmt.Errorf("sample count must be between 1 and 30")
+	}
+
+	if count > 1 && (interval < time.Second || interval > time.Minute) {
+		return nil, fmt.Errorf("sample interval must be between 1s and 1m")
+	}
+
+	var samples []Sample
+	var lastErr error
+
+	for i := 0; i < count; i++ {
+		if i > 0 {
+			time.Sleep(interval)
+		}
+
+		s, err := takeSample()
+
+		if err != nil {
+			if count > 1 {
+				log.Printf("Warning: sample %d of %d failed: %v", i+1, count, err)
+			}
+
+			lastErr = err
+			continue
+		}
+
+		samples = append(samples, s)
+	}
+
+	if len(samples) == 0 {
+		return nil, lastErr
+	}
+
+	return samples, nil
+}
+
+// average returns the mean of the value selected from each sample.
+func average(samples []Sample, value func(Sample) float64) float64 {
+	var total float64
+
+	for _, s := range samples {
+		total += value(s)
+	}
+
+	return total / float64(len(samples))
+}
+
+// aggregateVoltage combines the samples' voltages according to
+// --voltage-aggregation. Samples without a voltage are ignored, so a single
+// missed reading doesn't drag the average or minimum down to zero.
+func aggregateVoltage(samples []Sample) float64 {
+	var voltages []float64
+
+	for _, s := range samples {
+		if s.Voltage > 0 {
+			voltages = append(voltages, s.Voltage)
+		}
+	}
+
+	if len(voltages) == 0 {
+		return 0
+	}
+
+	switch opts.VoltageAggregation {
+	case "avg":
+		var total float64
+		for _, v := range voltages {
+			total += v
+		}
+
+		return total / float64(len(voltages))
+	case "min":
+		return slices.Min(voltages)
+	case "max":
+		return slices.Max(voltages)
+	default:
+		return voltages[len(voltages)-1]
+	}
+}
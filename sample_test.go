@@ -0,0 +1,78 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"testing"
+)
+
+func TestTakeSampleReportVoltageFrom(t *testing.T) {
+	tests := []struct {
+		fixture string
+		from    string
+		want    float64
+	}{
+		{"production_meters.json", "production", 242},
+		{"production_meters.json", "consumption", 238},
+		{"production_meters.json", "avg", 240},
+		{"production_no_consumption.json", "production", 242},
+		{"production_no_consumption.json", "consumption", 0},
+		{"production_no_consumption.json", "avg", 242},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.fixture+"/"+tt.from, func(t *testing.T) {
+			saved := opts
+			t.Cleanup(func() { opts = saved })
+			opts = Options{ReportVoltageFrom: tt.from}
+
+			body, err := os.ReadFile("testdata/" + tt.fixture)
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			envoyServer(t, func(w http.ResponseWriter, r *http.Request) {
+				w.Write(body)
+			})
+
+			s, err := takeSample()
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if s.Voltage != tt.want {
+				t.Errorf("voltage = %v, want %v", s.Voltage, tt.want)
+			}
+		})
+	}
+}
+
+func TestAggregateVoltage(t *testing.T) {
+	saved := opts
+	t.Cleanup(func() { opts = saved })
+
+	samples := []Sample{{Voltage: 240}, {Voltage: 0}, {Voltage: 246}, {Voltage: 237}}
+
+	tests := []struct {
+		aggregation string
+		samples     []Sample
+		want        float64
+	}{
+		{"last", samples, 237},
+		{"avg", samples, 241},
+		{"min", samples, 237},
+		{"max", samples, 246},
+		{"avg", []Sample{{Voltage: 0}, {Voltage: 0}}, 0},
+		{"last", []Sample{{Voltage: 241}, {Voltage: 0}}, 241},
+	}
+
+	for _, tt := range tests {
+		opts.VoltageAggregation = tt.aggregation
+
+		if got := aggregateVoltage(tt.samples); got != tt.want {
+			t.Errorf("aggregateVoltage(%s, %v) = %v, want %v", tt.aggregation, tt.samples, got, tt.want)
+		}
+	}
+}
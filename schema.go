@@ -0,0 +1,88 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"reflect"
+	"strings"
+)
+
+// envoySchemas lists the Envoy endpoints go-envoy reads and the types their
+// responses are decoded into.
+var envoySchemas = []struct {
+	Path string
+	Type any
+}{
+	{"/production.json", EnvoyResponse{}},
+	{"/ivp/meters", []Meter{}},
+	{"/ivp/meters/readings", []MeterReading{}},
+	{"/ivp/ensemble/inventory", []ensembleInventory{}},
+	{"/api/v1/production/inverters", []Inverter{}},
+}
+
+var rawMessageType = reflect.TypeOf(json.RawMessage{})
+
+// printSchema writes a JSON Schema for each endpoint's response, generated
+// from the Go types so it can't drift from what is actually decoded. Fields
+// tagged omitempty are not always present and are left out of "required";
+// any other field missing from a response decodes as its zero value.
+func printSchema(w io.Writer) error {
+	schemas := map[string]any{}
+
+	for _, s := range envoySchemas {
+		schemas[s.Path] = typeSchema(reflect.TypeOf(s.Type))
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+
+	return enc.Encode(schemas)
+}
+
+func typeSchema(t reflect.Type) map[string]any {
+	if t == rawMessageType {
+		// kept as raw JSON, so anything is accepted
+		return map[string]any{}
+	}
+
+	switch t.Kind() {
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return map[string]any{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		return map[string]any{"type": "array", "items": typeSchema(t.Elem())}
+	case reflect.Pointer:
+		return typeSchema(t.Elem())
+	case reflect.Struct:
+		properties := map[string]any{}
+		required := []string{}
+
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			name, options, _ := strings.Cut(f.Tag.Get("json"), ",")
+
+			if name == "-" || !f.IsExported() {
+				continue
+			}
+
+			if name == "" {
+				name = f.Name
+			}
+
+			properties[name] = typeSchema(f.Type)
+
+			if !strings.Contains(options, "omitempty") {
+				required = append(required, name)
+			}
+		}
+
+		return map[string]any{"type": "object", "properties": properties, "required": required}
+	default:
+		return map[string]any{}
+	}
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	sheetsAPI   = "https://sheets.googleapis.com/v4/spreadsheets"
+	sheetsScope = "https://www.googleapis.com/auth/spreadsheets"
+)
+
+// serviceAccount holds the fields of a Google service account key file that
+// are needed to request an access token.
+type serviceAccount struct {
+	ClientEmail string `json:"client_email"`
+	PrivateKey  string `json:"private_key"`
+	TokenURI    string `json:"token_uri"`
+}
+
+// appendToSheet appends the reading as a row of timestamp, power, energy and
+// voltage to a Google Sheet. A run only appends one row, so a fresh access
+// token is requested each time rather than cached between runs.
+func appendToSheet(r Reading) error {
+	token, err := sheetsAccessToken(opts.SheetsCredentials)
+
+	if err != nil {
+		return fmt.Errorf("google sheets authentication failed: %w", err)
+	}
+
+	body, err := json.Marshal(map[string]any{
+		"values": [][]any{{r.Date.Format("2006-01-02 15:04:05"), r.Power, r.wattHours(), r.Voltage}},
+	})
+
+	if err != nil {
+		return err
+	}
+
+	target := fmt.Sprintf("%s/%s/values/%s:append?valueInputOption=USER_ENTERED",
+		sheetsAPI, url.PathEscape(opts.SheetsID), url.PathEscape(opts.SheetsRange))
+
+	req, err := http.NewRequest("POST", target, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	resp, err := outputClient.Do(req)
+
+	if err != nil {
+		return err
+	}
+
+	defer closeBody(resp.Body)
+
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("google sheets append failed: %s %s", resp.Status, bytes.TrimSpace(msg))
+	}
+
+	return nil
+}
+
+// sheetsAccessToken exchanges a JWT signed with the service account's key for
+// an OAuth access token.
+func sheetsAccessToken(path string) (string, error) {
+	data, err := os.ReadFile(path)
+
+	if err != nil {
+		return "", fmt.Errorf("failed to read credentials: %w", err)
+	}
+
+	var sa serviceAccount
+	if err := json.Unmarshal(data, &sa); err != nil {
+		return "", fmt.Errorf("failed to parse credentials: %w", err)
+	}
+
+	assertion, err := signServiceAccountJWT(sa, time.Now())
+
+	if err != nil {
+		return "", err
+	}
+
+	resp, err := tokenClient().PostForm(sa.TokenURI, url.Values{
+		"grant_type": {"urn:ietf:params:oauth:grant-type:jwt-bearer"},
+		"assertion":  {assertion},
+	})
+
+	if err != nil {
+		return "", err
+	}
+
+	defer closeBody(resp.Body)
+
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return "", fmt.Errorf("token request failed: %s %s", resp.Status, bytes.TrimSpace(msg))
+	}
+
+	var token struct {
+		AccessToken string `json:"access_token"`
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return "", fmt.Errorf("failed to parse token response: %w", err)
+	}
+
+	return token.AccessToken, nil
+}
+
+// tokenClient returns outputClient without the --http-debug transport, so the
+// signed assertion and the access token are never written to the log.
+func tokenClient() *http.Client {
+	debug, ok := outputClient.Transport.(*debugTransport)
+
+	if !ok {
+		return outputClient
+	}
+
+	return &http.Client{Timeout: outputClient.Timeout, Transport: debug.next}
+}
+
+func signServiceAccountJWT(sa serviceAccount, now time.Time) (string, error) {
+	block, _ := pem.Decode([]byte(sa.PrivateKey))
+
+	if block == nil {
+		return "", fmt.Errorf("credentials contain no PEM private key")
+	}
+
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+
+	if err != nil {
+		return "", fmt.Errorf("failed to parse private key: %w", err)
+	}
+
+	key, ok := parsed.(*rsa.PrivateKey)
+	if !ok {
+		return "", fmt.Errorf("private key is not an RSA key")
+	}
+
+	claims, err := json.Marshal(map[string]any{
+		"iss":   sa.ClientEmail,
+		"scope": sheetsScope,
+		"aud":   sa.TokenURI,
+		"iat":   now.Unix(),
+		"exp":   now.Add(time.Hour).Unix(),
+	})
+
+	if err != nil {
+		return "", err
+	}
+
+	enc := base64.RawURLEncoding
+	unsigned := enc.EncodeToString([]byte(`{"alg":"RS256","typ":"JWT"}`)) + "." + enc.EncodeToString(claims)
+
+	digest := sha256.Sum256([]byte(unsigned))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+
+	if err != nil {
+		return "", fmt.Errorf("failed to sign token request: %w", err)
+	}
+
+	return strings.Join([]string{unsigned, enc.EncodeToString(sig)}, "."), nil
+}
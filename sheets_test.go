@@ -0,0 +1,74 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSheetsAccessTokenNotLogged(t *testing.T) {
+	saved := opts
+	t.Cleanup(func() { opts = saved })
+	opts = Options{HTTPDebug: true}
+	outputClient = newHTTPClient(time.Second, 0, nil, false, nil)
+
+	var assertion string
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assertion = r.PostFormValue("assertion")
+		w.Write([]byte(`{"access_token":"ya29.secret-token"}`))
+	}))
+	defer srv.Close()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	creds, _ := json.Marshal(serviceAccount{
+		ClientEmail: "envoy@example.iam.gserviceaccount.com",
+		PrivateKey:  string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})),
+		TokenURI:    srv.URL,
+	})
+
+	path := filepath.Join(t.TempDir(), "credentials.json")
+	if err := os.WriteFile(path, creds, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	var logged bytes.Buffer
+	log.SetOutput(&logged)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+
+	token, err := sheetsAccessToken(path)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if token != "ya29.secret-token" {
+		t.Errorf("token = %q", token)
+	}
+
+	if assertion == "" || strings.Contains(logged.String(), assertion) || strings.Contains(logged.String(), token) {
+		t.Errorf("token exchange was logged:\n%s", logged.String())
+	}
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// simulatedInstall is when the simulated system started producing, so the
+// lifetime counter looks like an established system's.
+var simulatedInstall = time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// simulateTransport answers /production.json with a synthetic reading
+// instead of contacting an Envoy. Power follows a half sine wave between
+// sunrise and sunset peaking at midday, and the lifetime energy is its
+// integral, so the daily energy calculation works as it would on real data.
+// Every other endpoint returns 404.
+type simulateTransport struct {
+	peak     float64
+	daylight timeWindow
+}
+
+// validateSimulate stops --simulate posting synthetic readings to a real
+// PVOutput system.
+func validateSimulate() error {
+	if !opts.Simulate || opts.NoPVOutput {
+		return nil
+	}
+
+	if strings.TrimSuffix(opts.PVOutputURL, "/") == defaultPVOutputURL {
+		return fmt.Errorf("--simulate needs --no-pvoutput or a test --pvoutput-url, so synthetic data isn't posted to %s", defaultPVOutputURL)
+	}
+
+	return nil
+}
+
+func newSimulateTransport(peak int, daylight string) (*simulateTransport, error) {
+	w, err := parseTimeWindow(daylight)
+
+	if err != nil {
+		return nil, fmt.Errorf("invalid --simulate-daylight: %w", err)
+	}
+
+	if w.start >= w.end {
+		return nil, fmt.Errorf("invalid --simulate-daylight: sunrise must be before sunset")
+	}
+
+	return &simulateTransport{peak: float64(peak), daylight: w}, nil
+}
+
+// at returns the simulated power and lifetime energy at t.
+func (t *simulateTransport) at(now time.Time) (watts, whLifetime float64) {
+	midnight := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	dayLength := (t.daylight.end - t.daylight.start).Hours()
+
+	// fraction of the way from sunrise to sunset, clamped to the day
+	progress := math.Min(math.Max((now.Sub(midnight)-t.daylight.start).Hours()/dayLength, 0), 1)
+
+	if progress > 0 && progress < 1 {
+		watts = t.peak * math.Sin(math.Pi*progress)
+	}
+
+	// the integral of the half sine over a whole day is peak*hours*2/pi
+	daily := t.peak * dayLength * 2 / math.Pi
+	today := daily * (1 - math.Cos(math.Pi*progress)) / 2
+	days := math.Round(midnight.Sub(simulatedInstall).Hours() / 24)
+
+	return watts, days*daily + today
+}
+
+func (t *simulateTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.URL.Path != "/production.json" {
+		return simulatedResponse(req, http.StatusNotFound, nil), nil
+	}
+
+	watts, lifetime := t.at(time.Now())
+	watts = math.Round(watts)
+	lifetime = math.Round(lifetime)
+
+	body, err := json.Marshal(EnvoyResponse{
+		Production: []ProductionEntry{
+			{Type: "inverters", ActiveCount: 1, WNow: watts, WhLifetime: lifetime},
+			{Type: "eim", MeasurementType: "production", ActiveCount: 1, WNow: watts, WhLifetime: lifetime, RMSVoltage: 240},
+		},
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return simulatedResponse(req, http.StatusOK, body), nil
+}
+
+func simulatedResponse(req *http.Request, status int, body []byte) *http.Response {
+	return &http.Response{
+		StatusCode:    status,
+		Status:        fmt.Sprintf("%d %s", status, http.StatusText(status)),
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        http.Header{"Content-Type": {"application/json"}},
+		Body:          io.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}
+}
@@ -0,0 +1,28 @@
+package main
+
+import "testing"
+
+func TestValidateSimulate(t *testing.T) {
+	saved := opts
+	t.Cleanup(func() { opts = saved })
+
+	tests := []struct {
+		name    string
+		opts    Options
+		wantErr bool
+	}{
+		{"not simulating", Options{PVOutputURL: defaultPVOutputURL}, false},
+		{"live PVOutput", Options{Simulate: true, PVOutputURL: defaultPVOutputURL}, true},
+		{"live PVOutput with a trailing slash", Options{Simulate: true, PVOutputURL: defaultPVOutputURL + "/"}, true},
+		{"no PVOutput", Options{Simulate: true, PVOutputURL: defaultPVOutputURL, NoPVOutput: true}, false},
+		{"test server", Options{Simulate: true, PVOutputURL: "http://localhost:8080"}, false},
+	}
+
+	for _, tt := range tests {
+		opts = tt.opts
+
+		if err := validateSimulate(); (err != nil) != tt.wantErr {
+			t.Errorf("%s: validateSimulate() = %v, want error %v", tt.name, err, tt.wantErr)
+		}
+	}
+}
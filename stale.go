@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"time"
+)
+
+// timeWindow is a daily time range such as 06:00-22:00. A window whose end
+// is before its start wraps past midnight, and one whose start and end are
+// equal covers the whole day.
+type timeWindow struct {
+	start time.Duration // offset from midnight
+	end   time.Duration
+}
+
+func parseTimeWindow(s string) (timeWindow, error) {
+	var sh, sm, eh, em int
+
+	if _, err := fmt.Sscanf(s, "%d:%d-%d:%d", &sh, &sm, &eh, &em); err != nil {
+		return timeWindow{}, fmt.Errorf("%q is not in the form HH:MM-HH:MM", s)
+	}
+
+	for _, v := range []int{sh, eh} {
+		if v < 0 || v > 24 {
+			return timeWindow{}, fmt.Errorf("%q has an invalid hour", s)
+		}
+	}
+
+	for _, v := range []int{sm, em} {
+		if v < 0 || v > 59 {
+			return timeWindow{}, fmt.Errorf("%q has an invalid minute", s)
+		}
+	}
+
+	return timeWindow{
+		start: time.Duration(sh)*time.Hour + time.Duration(sm)*time.Minute,
+		end:   time.Duration(eh)*time.Hour + time.Duration(em)*time.Minute,
+	}, nil
+}
+
+// contains reports whether t's local time of day falls within the window.
+func (w timeWindow) contains(t time.Time) bool {
+	offset := time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute
+
+	if w.start == w.end {
+		return true
+	}
+
+	if w.start < w.end {
+		return offset >= w.start && offset < w.end
+	}
+
+	return offset >= w.start || offset < w.end
+}
+
+// checkStaleToday warns when today's energy has not increased over several
+// consecutive runs during daylight while significant power is being reported,
+// which points at a problem with the state file or lifetime counter.
+func checkStaleToday(now time.Time, power, energy int) error {
+	daylight, err := parseTimeWindow(opts.StaleHours)
+
+	if err != nil {
+		return fmt.Errorf("invalid --stale-hours: %w", err)
+	}
+
+	s, err := loadState()
+
+	if err != nil {
+		return err
+	}
+
+	flatRuns := 0
+
+	if daylight.contains(now) && power >= opts.StaleMinPower && float64(energy) == s.LastEnergy {
+		flatRuns = s.FlatRuns + 1
+	}
+
+	if flatRuns >= opts.StaleRuns {
+		log.Printf("Warning: today's energy has been stuck at %dWh for %d runs while producing %dW, check the state file and lifetime counter", energy, flatRuns, power)
+	}
+
+	if flatRuns == s.FlatRuns && float64(energy) == s.LastEnergy {
+		return nil
+	}
+
+	s.FlatRuns = flatRuns
+	s.LastEnergy = float64(energy)
+
+	return saveState(s)
+}
@@ -0,0 +1,317 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+type State struct {
+	Date      string             `json:"date"`      // format: YYYY-MM-DD
+	Baselines map[string]float64 `json:"baselines"` // counter name => lifetime value at midnight
+
+	// Baseline is the single whLifetime baseline written by earlier versions.
+	// It is migrated into Baselines[energyCounter] when the state is loaded.
+	Baseline float64 `json:"baseline,omitempty"`
+
+	// Lifetimes holds each counter's previous lifetime value, which drops
+	// are measured from to tell a glitch from a meter reset.
+	Lifetimes map[string]float64 `json:"lifetimes,omitempty"`
+
+	// LastLifetime is the last lifetime value posted in cumulative mode.
+	LastLifetime float64 `json:"lastLifetime,omitempty"`
+
+	// LastEnergy and FlatRuns track how long today's energy has been
+	// unchanged for --warn-on-stale-today.
+	LastEnergy float64 `json:"lastEnergy,omitempty"`
+	FlatRuns   int     `json:"flatRuns,omitempty"`
+
+	// LastSlot is the start of the last --dedupe-window slot posted to
+	// PVOutput, in RFC 3339 format.
+	LastSlot string `json:"lastSlot,omitempty"`
+
+	// SystemSlots holds LastSlot for each --pvoutput-system, keyed by
+	// system id.
+	SystemSlots map[string]string `json:"systemSlots,omitempty"`
+
+	// DailyEnergy holds each recent day's energy (Wh), keyed by YYYY-MM-DD,
+	// for --energy-history.
+	DailyEnergy map[string]float64 `json:"dailyEnergy,omitempty"`
+
+	// RatedPower is the system size (W) from PVOutput, fetched at the Unix
+	// time RatedPowerFetched, for --auto-max-power.
+	RatedPower        int   `json:"ratedPower,omitempty"`
+	RatedPowerFetched int64 `json:"ratedPowerFetched,omitempty"`
+
+	// PrevLifetime, PrevLifetimeAt (Unix time) and PrevPower are the
+	// previous run's lifetime energy and power, for --check-implied-power.
+	PrevLifetime   float64 `json:"prevLifetime,omitempty"`
+	PrevLifetimeAt int64   `json:"prevLifetimeAt,omitempty"`
+	PrevPower      float64 `json:"prevPower,omitempty"`
+}
+
+// statePath is where the state file is kept. It is a variable so tests can
+// use a temporary file.
+var statePath = "/data/state.json"
+
+// isolatedStatePath returns the state file used instead of statePath by a
+// run that doesn't read the real Envoy, such as --simulate or --replay, so
+// its lifetime counters never touch the real system's baselines.
+func isolatedStatePath(mode string) string {
+	return filepath.Join(filepath.Dir(statePath), "state-"+mode+".json")
+}
+
+// nearZeroLifetime is the lifetime value (Wh) below which a counter reading is
+// assumed to be a transient from an Envoy that has just booted rather than a
+// real value.
+const nearZeroLifetime = 10
+
+// energyCounter is the name of the baseline used for PVOutput's v1 energy.
+const energyCounter = "energy"
+
+func calculateTodaysWattHours(now time.Time, whLifetime float64) float64 {
+	today, err := loadOrInit(now, map[string]float64{energyCounter: whLifetime})
+
+	if err != nil {
+		log.Printf("Warning: could not load state file, defaulting to zero: %v", err)
+		return 0
+	}
+
+	return today[energyCounter]
+}
+
+// loadOrInit returns how much each of the given lifetime counters has
+// increased since midnight on the day of now. Counters without a baseline for
+// today (new day, missing state file or a newly added counter) start from
+// zero.
+func loadOrInit(now time.Time, counters map[string]float64) (map[string]float64, error) {
+	today := now.Format("2006-01-02")
+
+	s, err := loadState()
+
+	if err != nil {
+		return nil, err
+	}
+
+	changed := s.migrate()
+
+	// a state without a date has never been initialised
+	firstRun := s.Date == ""
+
+	if s.Date != today {
+		// new day, reset all baselines
+		s.Date = today
+		s.Baselines = map[string]float64{}
+		changed = true
+	}
+
+	deltas := make(map[string]float64, len(counters))
+
+	for name, current := range counters {
+		baseline, ok := s.Baselines[name]
+		previous, seen := s.Lifetimes[name]
+
+		if !seen && ok {
+			// state from before previous readings were kept
+			previous, seen = baseline, true
+		}
+
+		if current < nearZeroLifetime && seen && previous >= nearZeroLifetime {
+			// some firmware briefly reports a zero lifetime after booting;
+			// re-baselining from it would report the whole lifetime as
+			// today's energy once the real value comes back. Counters that
+			// have never got past zero, like export on an export-limited
+			// system, are genuinely zero.
+			log.Printf("Warning: ignoring implausible %s lifetime of %.0f, keeping the existing baseline", name, current)
+
+			deltas[name] = 0
+			if ok {
+				deltas[name] = max(previous-baseline, 0)
+			}
+
+			continue
+		}
+
+		if seen && current < previous {
+			if previous-current < opts.ResetThreshold {
+				// a small drop is a glitch, hold the previous reading so
+				// today's energy doesn't go backwards
+				current = previous
+			} else {
+				log.Printf("Warning: %s counter dropped from %.0f to %.0f, treating as a meter reset", name, previous, current)
+
+				if ok {
+					// carry on from the new value without losing the
+					// energy already counted today
+					baseline = current - (previous - baseline)
+					s.Baselines[name] = baseline
+					changed = true
+				}
+			}
+		}
+
+		if !ok {
+			baseline = current
+
+			if firstRun && name == energyCounter && opts.FirstRunEnergy > 0 {
+				baseline = max(current-opts.FirstRunEnergy, 0)
+				log.Printf("First run: seeding today's energy with %.0fWh, baseline set to %.0f", current-baseline, baseline)
+			}
+
+			s.Baselines[name] = baseline
+			changed = true
+		}
+
+		if !seen || current != previous {
+			s.Lifetimes[name] = current
+			changed = true
+		}
+
+		deltas[name] = current - baseline
+	}
+
+	if changed {
+		if err := saveState(s); err != nil {
+			return nil, err
+		}
+	}
+
+	return deltas, nil
+}
+
+// cumulativeLifetime guards the lifetime energy posted in cumulative mode
+// against going backwards. A small drop reposts the previous value so
+// PVOutput sees no change, while a drop beyond --reset-threshold is accepted
+// as a genuine meter reset.
+func cumulativeLifetime(whLifetime float64) float64 {
+	s, err := loadState()
+
+	if err != nil {
+		log.Printf("Warning: could not load state file, skipping lifetime guard: %v", err)
+		return whLifetime
+	}
+
+	last := s.LastLifetime
+
+	if whLifetime < nearZeroLifetime && last >= nearZeroLifetime {
+		log.Printf("Warning: ignoring implausible lifetime of %.0f, reposting previous value", whLifetime)
+		return last
+	}
+
+	if whLifetime < last {
+		if last-whLifetime < opts.ResetThreshold {
+			log.Printf("Warning: lifetime counter went backwards from %.0f to %.0f, reposting previous value", last, whLifetime)
+			return last
+		}
+
+		log.Printf("Warning: lifetime counter dropped from %.0f to %.0f, treating as a meter reset", last, whLifetime)
+	}
+
+	if whLifetime != last {
+		s.LastLifetime = whLifetime
+
+		if err := saveState(s); err != nil {
+			log.Printf("Warning: could not save state file: %v", err)
+		}
+	}
+
+	return whLifetime
+}
+
+// validateStateless checks that nothing needing the state file is enabled
+// alongside --stateless.
+func validateStateless() error {
+	if !opts.Stateless {
+		return nil
+	}
+
+	if opts.EnergyMode != "cumulative" {
+		return fmt.Errorf("--stateless requires --energy-mode cumulative, daily energy needs a midnight baseline")
+	}
+
+	if opts.GridEnergy || opts.WarnOnStaleToday || opts.DedupeWindow > 0 || opts.EnergyHistory || opts.AutoMaxPower || opts.CheckImpliedPower {
+		return fmt.Errorf("--stateless can't be used with --grid-energy, --warn-on-stale-today, --dedupe-window, --energy-history, --auto-max-power or --check-implied-power")
+	}
+
+	return nil
+}
+
+// migrate moves a legacy single baseline into the named counters and reports
+// whether the state was modified.
+func (s *State) migrate() bool {
+	if s.Baselines == nil {
+		s.Baselines = map[string]float64{}
+	}
+
+	if s.Lifetimes == nil {
+		s.Lifetimes = map[string]float64{}
+	}
+
+	if s.Baseline == 0 {
+		return false
+	}
+
+	if _, ok := s.Baselines[energyCounter]; !ok {
+		s.Baselines[energyCounter] = s.Baseline
+	}
+
+	s.Baseline = 0
+
+	return true
+}
+
+// loadState reads the state file, returning an empty state if it does not
+// exist yet.
+func loadState() (State, error) {
+	f, err := os.Open(statePath)
+
+	if os.IsNotExist(err) {
+		return State{}, nil
+	}
+
+	if err != nil {
+		return State{}, fmt.Errorf("failed to read state file: %w", err)
+	}
+
+	defer f.Close()
+
+	var s State
+	if err := json.NewDecoder(f).Decode(&s); err != nil {
+		return State{}, fmt.Errorf("failed to parse state file: %w", err)
+	}
+
+	return s, nil
+}
+
+// saveState writes the state file, skipping the write entirely when the file
+// already holds the same state so an unchanged baseline causes no disk I/O.
+// The file is replaced atomically so a crash can't leave it half written.
+func saveState(s State) error {
+	data, err := json.Marshal(s)
+
+	if err != nil {
+		return fmt.Errorf("failed to encode state: %w", err)
+	}
+
+	data = append(data, '\n')
+
+	if existing, err := os.ReadFile(statePath); err == nil && bytes.Equal(existing, data) {
+		return nil
+	}
+
+	tmp := statePath + ".tmp"
+
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return fmt.Errorf("failed to write state file: %w", err)
+	}
+
+	if err := os.Rename(tmp, statePath); err != nil {
+		return fmt.Errorf("failed to write state file: %w", err)
+	}
+
+	return nil
+}
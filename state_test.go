@@ -0,0 +1,323 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// useTempState points the state file at a temporary directory and resets the
+// options for the duration of the test.
+func useTempState(t *testing.T) {
+	t.Helper()
+
+	path, saved := statePath, opts
+	statePath = filepath.Join(t.TempDir(), "state.json")
+	opts = Options{ResetThreshold: 1000}
+
+	t.Cleanup(func() {
+		statePath, opts = path, saved
+	})
+}
+
+func writeState(t *testing.T, data string) {
+	t.Helper()
+
+	if err := os.WriteFile(statePath, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLoadOrInitCounters(t *testing.T) {
+	useTempState(t)
+
+	day1 := time.Date(2024, 6, 1, 12, 0, 0, 0, time.Local)
+	day2 := day1.AddDate(0, 0, 1)
+
+	steps := []struct {
+		name     string
+		now      time.Time
+		counters map[string]float64
+		want     map[string]float64
+	}{
+		{"first run sets baselines", day1, map[string]float64{"energy": 1000, "import": 500}, map[string]float64{"energy": 0, "import": 0}},
+		{"same day counts from each baseline", day1.Add(time.Hour), map[string]float64{"energy": 1800, "import": 650}, map[string]float64{"energy": 800, "import": 150}},
+		{"new counter starts from zero", day1.Add(2 * time.Hour), map[string]float64{"energy": 2000, "import": 700, "export": 300}, map[string]float64{"energy": 1000, "import": 200, "export": 0}},
+		{"midnight resets every counter", day2, map[string]float64{"energy": 2500, "import": 900, "export": 320}, map[string]float64{"energy": 0, "import": 0, "export": 0}},
+		{"next day counts from new baselines", day2.Add(time.Hour), map[string]float64{"energy": 2600, "import": 950, "export": 400}, map[string]float64{"energy": 100, "import": 50, "export": 80}},
+	}
+
+	for _, step := range steps {
+		got, err := loadOrInit(step.now, step.counters)
+
+		if err != nil {
+			t.Fatalf("%s: %v", step.name, err)
+		}
+
+		for name, want := range step.want {
+			if got[name] != want {
+				t.Errorf("%s: %s = %v, want %v", step.name, name, got[name], want)
+			}
+		}
+	}
+}
+
+func TestLoadOrInitMigratesLegacyBaseline(t *testing.T) {
+	useTempState(t)
+
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.Local)
+	writeState(t, `{"date":"2024-06-01","baseline":1000}`)
+
+	got, err := loadOrInit(now, map[string]float64{energyCounter: 1500})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got[energyCounter] != 500 {
+		t.Errorf("energy = %v, want 500 from the migrated baseline", got[energyCounter])
+	}
+
+	s, err := loadState()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if s.Baseline != 0 || s.Baselines[energyCounter] != 1000 {
+		t.Errorf("state after migration = %+v, want the baseline moved into baselines", s)
+	}
+}
+
+func TestLoadOrInitResetThreshold(t *testing.T) {
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.Local)
+
+	tests := []struct {
+		name    string
+		current []float64 // successive readings after baseline 4000 and previous 5000
+		want    float64   // today's energy after the last reading
+	}{
+		{"increase", []float64{5200}, 1200},
+		{"drop below the threshold is held", []float64{4990}, 1000},
+		{"drop above the baseline is held", []float64{4500}, 1000},
+		{"counting continues after a glitch", []float64{4990, 5010}, 1010},
+		{"drop of the threshold is a reset", []float64{4000}, 1000},
+		{"reset keeps today's energy", []float64{100}, 1000},
+		{"counting continues after a reset", []float64{100, 150}, 1050},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useTempState(t)
+			writeState(t, `{"date":"2024-06-01","baselines":{"energy":4000},"lifetimes":{"energy":5000}}`)
+
+			var got float64
+
+			for _, current := range tt.current {
+				deltas, err := loadOrInit(now, map[string]float64{energyCounter: current})
+
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				got = deltas[energyCounter]
+			}
+
+			if got != tt.want {
+				t.Errorf("today's energy = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadOrInitBootTransient(t *testing.T) {
+	useTempState(t)
+
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.Local)
+	writeState(t, `{"date":"2024-06-01","baselines":{"energy":12000000},"lifetimes":{"energy":12005000}}`)
+
+	// the Envoy reboots and briefly reports a zero lifetime, then recovers
+	for i, step := range []struct {
+		lifetime float64
+		want     float64
+	}{
+		{0, 5000},
+		{12006000, 6000},
+	} {
+		deltas, err := loadOrInit(now.Add(time.Duration(i)*time.Minute), map[string]float64{energyCounter: step.lifetime})
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if deltas[energyCounter] != step.want {
+			t.Errorf("lifetime %v: today's energy = %v, want %v", step.lifetime, deltas[energyCounter], step.want)
+		}
+	}
+
+	s, err := loadState()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if s.Baselines[energyCounter] != 12000000 {
+		t.Errorf("baseline = %v, want it kept at 12000000", s.Baselines[energyCounter])
+	}
+}
+
+func TestLoadOrInitSkipsUnchangedWrites(t *testing.T) {
+	useTempState(t)
+
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.Local)
+
+	if _, err := loadOrInit(now, map[string]float64{energyCounter: 5000}); err != nil {
+		t.Fatal(err)
+	}
+
+	// backdate the file so any rewrite is visible regardless of the
+	// filesystem's timestamp resolution
+	past := time.Now().Add(-time.Hour).Truncate(time.Second)
+
+	if err := os.Chtimes(statePath, past, past); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 1; i <= 3; i++ {
+		if _, err := loadOrInit(now.Add(time.Duration(i)*time.Minute), map[string]float64{energyCounter: 5000}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	info, err := os.Stat(statePath)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !info.ModTime().Equal(past) {
+		t.Errorf("state file was rewritten on same-day runs with an unchanged baseline")
+	}
+}
+
+func TestLoadOrInitNearZeroGuard(t *testing.T) {
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.Local)
+
+	tests := []struct {
+		name     string
+		state    string
+		lifetime float64
+		want     float64
+		baseline bool // whether a baseline should now exist
+	}{
+		{"new counter that is zero is baselined", `{}`, 0, 0, true},
+		{"counter that has stayed zero keeps counting", `{"date":"2024-06-01","baselines":{"export":0},"lifetimes":{"export":0}}`, 4, 4, true},
+		{"zero after a real value is ignored", `{"date":"2024-06-01","baselines":{"export":5000},"lifetimes":{"export":5200}}`, 0, 200, true},
+		{"zero on a new day is ignored", `{"date":"2024-05-31","baselines":{"export":5000},"lifetimes":{"export":5200}}`, 0, 0, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useTempState(t)
+			writeState(t, tt.state)
+
+			deltas, err := loadOrInit(now, map[string]float64{"export": tt.lifetime})
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if deltas["export"] != tt.want {
+				t.Errorf("export today = %v, want %v", deltas["export"], tt.want)
+			}
+
+			s, err := loadState()
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if _, ok := s.Baselines["export"]; ok != tt.baseline {
+				t.Errorf("baseline present = %v, want %v", ok, tt.baseline)
+			}
+		})
+	}
+}
+
+func TestValidateStateless(t *testing.T) {
+	saved := opts
+	t.Cleanup(func() { opts = saved })
+
+	tests := []struct {
+		name    string
+		opts    Options
+		wantErr bool
+	}{
+		{"off", Options{EnergyMode: "daily"}, false},
+		{"cumulative", Options{Stateless: true, EnergyMode: "cumulative"}, false},
+		{"daily", Options{Stateless: true, EnergyMode: "daily"}, true},
+		{"dedupe window", Options{Stateless: true, EnergyMode: "cumulative", DedupeWindow: 5 * time.Minute}, true},
+		{"grid energy", Options{Stateless: true, EnergyMode: "cumulative", GridEnergy: true}, true},
+	}
+
+	for _, tt := range tests {
+		opts = tt.opts
+
+		if err := validateStateless(); (err != nil) != tt.wantErr {
+			t.Errorf("%s: validateStateless() = %v, want error %v", tt.name, err, tt.wantErr)
+		}
+	}
+}
+
+func TestLoadOrInitFirstRunEnergy(t *testing.T) {
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.Local)
+
+	tests := []struct {
+		name        string
+		state       string
+		firstRun    float64
+		counters    map[string]float64
+		want        map[string]float64
+		wantLaterWh float64
+	}{
+		{"seeds today's energy", "", 2500, map[string]float64{"energy": 10000, "import": 400}, map[string]float64{"energy": 2500, "import": 0}, 2600},
+		{"baseline can't go below zero", "", 2500, map[string]float64{"energy": 1000}, map[string]float64{"energy": 1000}, 1100},
+		{"not set", "", 0, map[string]float64{"energy": 10000}, map[string]float64{"energy": 0}, 100},
+		{"ignored once a state file exists", `{"date":"2024-05-31","baselines":{"energy":9000},"lifetimes":{"energy":9900}}`, 2500, map[string]float64{"energy": 10000}, map[string]float64{"energy": 0}, 100},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useTempState(t)
+			opts.FirstRunEnergy = tt.firstRun
+
+			if tt.state != "" {
+				writeState(t, tt.state)
+			}
+
+			got, err := loadOrInit(now, tt.counters)
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for name, want := range tt.want {
+				if got[name] != want {
+					t.Errorf("%s = %v, want %v", name, got[name], want)
+				}
+			}
+
+			// the seeded baseline is kept for the rest of the day
+			later, err := loadOrInit(now.Add(time.Hour), map[string]float64{"energy": tt.counters["energy"] + 100})
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if later["energy"] != tt.wantLaterWh {
+				t.Errorf("an hour later energy = %v, want %v", later["energy"], tt.wantLaterWh)
+			}
+		})
+	}
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+// sendStatsD emits the reading as StatsD gauges over UDP. Delivery is fire
+// and forget, so only failures to resolve or write are reported.
+func sendStatsD(r Reading) error {
+	conn, err := net.DialTimeout("udp", opts.StatsDAddr, 5*time.Second)
+
+	if err != nil {
+		return fmt.Errorf("failed to connect to statsd: %w", err)
+	}
+
+	defer conn.Close()
+
+	prefix := strings.Trim(opts.StatsDPrefix, ".")
+	if opts.SystemID != "" {
+		prefix += "." + opts.SystemID
+	}
+
+	var buf bytes.Buffer
+
+	fmt.Fprintf(&buf, "%s.power:%d|g\n", prefix, r.Power)
+	fmt.Fprintf(&buf, "%s.energy:%d|g\n", prefix, r.wattHours())
+
+	if r.Voltage > 0 {
+		fmt.Fprintf(&buf, "%s.voltage:%d|g\n", prefix, r.Voltage)
+	}
+
+	for _, name := range r.extraNames() {
+		fmt.Fprintf(&buf, "%s.%s:%g|g\n", prefix, name, r.Extra[name])
+	}
+
+	if _, err := conn.Write(buf.Bytes()); err != nil {
+		return fmt.Errorf("failed to send statsd metrics: %w", err)
+	}
+
+	return nil
+}
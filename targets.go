@@ -0,0 +1,168 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+)
+
+// pvoutputTarget is a PVOutput system that each reading is posted to, with
+// its own energy mode and extended parameters.
+type pvoutputTarget struct {
+	Config
+	Name       string // used in logs and errors
+	EnergyMode string
+	Extended   map[string]string
+}
+
+// parsePVOutputSystems parses the --pvoutput-system options, each a system id
+// mapped to comma-separated settings, e.g.
+// 54321:energy-mode=cumulative,v7=self_consumption. Systems are returned in
+// order of system id.
+func parsePVOutputSystems(systems map[string]string) ([]pvoutputTarget, error) {
+	if len(systems) > 0 && opts.NoPVOutput {
+		return nil, fmt.Errorf("can't be used with --no-pvoutput")
+	}
+
+	ids := make([]string, 0, len(systems))
+	for id := range systems {
+		ids = append(ids, id)
+	}
+
+	sort.Strings(ids)
+
+	var targets []pvoutputTarget
+
+	for _, id := range ids {
+		if id == opts.SystemID {
+			return nil, fmt.Errorf("system %s is already the --system-id", id)
+		}
+
+		t := pvoutputTarget{
+			Config:     Config{APIKey: opts.ApiKey, SystemID: id},
+			Name:       "PVOutput system " + id,
+			EnergyMode: opts.EnergyMode,
+			Extended:   map[string]string{},
+		}
+
+		for _, setting := range strings.Split(systems[id], ",") {
+			if setting == "" {
+				continue
+			}
+
+			key, value, ok := strings.Cut(setting, "=")
+
+			if !ok {
+				return nil, fmt.Errorf("system %s: setting %q is not key=value", id, setting)
+			}
+
+			switch key {
+			case "api-key":
+				t.APIKey = value
+			case "energy-mode":
+				if value != "daily" && value != "cumulative" {
+					return nil, fmt.Errorf("system %s: energy-mode must be daily or cumulative", id)
+				}
+
+				t.EnergyMode = value
+			default:
+				t.Extended[key] = value
+			}
+		}
+
+		if err := validateExtendedFields(t.Extended); err != nil {
+			return nil, fmt.Errorf("system %s: %w", id, err)
+		}
+
+		if t.APIKey == "" {
+			return nil, fmt.Errorf("system %s needs an api-key setting or --api-key", id)
+		}
+
+		if opts.Stateless && t.EnergyMode != "cumulative" {
+			return nil, fmt.Errorf("system %s: --stateless requires energy-mode=cumulative", id)
+		}
+
+		if opts.PVOutputVersion == "r1" && (t.EnergyMode == "cumulative" || len(t.Extended) > 0) {
+			return nil, fmt.Errorf("system %s: cumulative energy and extended parameters require the r2 endpoint", id)
+		}
+
+		targets = append(targets, t)
+	}
+
+	return targets, nil
+}
+
+// energyModes returns the energy modes needed by --energy-mode and the
+// additional systems, so each is only calculated once per run.
+func energyModes(systems []pvoutputTarget) []string {
+	modes := []string{opts.EnergyMode}
+
+	for _, t := range systems {
+		if !slices.Contains(modes, t.EnergyMode) {
+			modes = append(modes, t.EnergyMode)
+		}
+	}
+
+	return modes
+}
+
+// modeEnergy returns the energy to post in the given mode: today's energy
+// from the midnight baseline, or the lifetime energy.
+func modeEnergy(mode string, now time.Time, whLifetime float64) float64 {
+	if mode != "cumulative" {
+		return calculateTodaysWattHours(now, whLifetime)
+	}
+
+	if opts.Stateless {
+		return whLifetime
+	}
+
+	return cumulativeLifetime(whLifetime)
+}
+
+// postStatuses posts the reading to each PVOutput system in turn, skipping
+// the rest once the output budget is used up and recovering from a panic
+// while posting to any one of them.
+func postStatuses(targets []pvoutputTarget, r Reading, energies map[string]float64) []error {
+	var errs []error
+
+	for _, t := range targets {
+		if outputBudgetExceeded(0) {
+			log.Printf("Warning: output budget of %v used up, skipping %s", opts.OutputBudget, t.Name)
+			continue
+		}
+
+		post := func() error { return postStatus(t, r, energies) }
+
+		if err := recoverSend(t.Name, post); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return errs
+}
+
+// postStatus posts the reading to one PVOutput system, with the energy for
+// the system's energy mode. A status for a --dedupe-window slot already posted
+// to this system is skipped; otherwise PVOutput updates the status it has for
+// the same date and time.
+func postStatus(t pvoutputTarget, r Reading, energies map[string]float64) error {
+	r.Energy = energies[t.EnergyMode]
+	r.Cumulative = t.EnergyMode == "cumulative"
+
+	if slotPosted(t.SystemID, r.Date) {
+		log.Printf("A status was already posted to %s for the slot containing %s, skipping", t.Name, r.Date.Format("15:04"))
+		return nil
+	}
+
+	if err := upload(t, r); err != nil {
+		return fmt.Errorf("upload to %s failed: %w", t.Name, err)
+	}
+
+	markSlotPosted(t.SystemID, r.Date)
+
+	return nil
+}
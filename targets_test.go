@@ -0,0 +1,195 @@
+package main
+
+import (
+	"errors"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestModeEnergy(t *testing.T) {
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.Local)
+
+	tests := []struct {
+		name     string
+		mode     string
+		state    string
+		lifetime float64
+		want     float64
+	}{
+		{"daily subtracts the midnight baseline", "daily", `{"date":"2024-06-01","baselines":{"energy":10000}}`, 12500, 2500},
+		{"daily starts a new day from zero", "daily", `{"date":"2024-05-31","baselines":{"energy":10000}}`, 12500, 0},
+		{"cumulative posts the lifetime", "cumulative", `{"date":"2024-06-01","baselines":{"energy":10000}}`, 12500, 12500},
+		{"cumulative holds a small drop", "cumulative", `{"lastLifetime":12600}`, 12500, 12600},
+		{"cumulative passes a reset through", "cumulative", `{"lastLifetime":50000}`, 12500, 12500},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useTempState(t)
+			writeState(t, tt.state)
+
+			if got := modeEnergy(tt.mode, now, tt.lifetime); got != tt.want {
+				t.Errorf("modeEnergy(%q) = %v, want %v", tt.mode, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestModeEnergyStateless(t *testing.T) {
+	useTempState(t)
+	opts.Stateless = true
+
+	for _, lifetime := range []float64{12500, 12400} {
+		if got := modeEnergy("cumulative", time.Now(), lifetime); got != lifetime {
+			t.Errorf("modeEnergy(%v) = %v, want the lifetime unchanged", lifetime, got)
+		}
+	}
+
+	if _, err := os.Stat(statePath); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("state file was created: %v", err)
+	}
+}
+
+func TestParsePVOutputSystems(t *testing.T) {
+	saved := opts
+	t.Cleanup(func() { opts = saved })
+
+	tests := []struct {
+		name    string
+		systems map[string]string
+		want    []pvoutputTarget
+		wantErr bool
+	}{
+		{
+			name:    "defaults from the main options",
+			systems: map[string]string{"54321": ""},
+			want: []pvoutputTarget{{
+				Config: Config{APIKey: "main-key", SystemID: "54321"}, Name: "PVOutput system 54321",
+				EnergyMode: "daily", Extended: map[string]string{},
+			}},
+		},
+		{
+			name:    "settings, sorted by system id",
+			systems: map[string]string{"60000": "api-key=other", "54321": "energy-mode=cumulative,v7=self_consumption"},
+			want: []pvoutputTarget{
+				{
+					Config: Config{APIKey: "main-key", SystemID: "54321"}, Name: "PVOutput system 54321",
+					EnergyMode: "cumulative", Extended: map[string]string{"v7": "self_consumption"},
+				},
+				{
+					Config: Config{APIKey: "other", SystemID: "60000"}, Name: "PVOutput system 60000",
+					EnergyMode: "daily", Extended: map[string]string{},
+				},
+			},
+		},
+		{name: "the main system", systems: map[string]string{"12345": ""}, wantErr: true},
+		{name: "setting without a value", systems: map[string]string{"54321": "cumulative"}, wantErr: true},
+		{name: "unknown energy mode", systems: map[string]string{"54321": "energy-mode=weekly"}, wantErr: true},
+		{name: "unknown extended parameter", systems: map[string]string{"54321": "v13=self_consumption"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts = Options{ApiKey: "main-key", SystemID: "12345", EnergyMode: "daily", PVOutputVersion: "r2"}
+
+			got, err := parsePVOutputSystems(tt.systems)
+
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parsePVOutputSystems() = %v, want error %v", err, tt.wantErr)
+			}
+
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parsePVOutputSystems() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPostStatusDedupesPerSystem(t *testing.T) {
+	useTempState(t)
+	opts.SystemID = "1"
+	opts.DedupeWindow = 5 * time.Minute
+	forms := pvoutputServer(t, http.StatusOK, "OK 200: Added Status")
+
+	primary := testTarget()
+	other := testTarget()
+	other.SystemID = "2"
+	other.Name = "PVOutput system 2"
+
+	r := Reading{Date: time.Now().Add(-time.Hour).Truncate(5 * time.Minute), Power: 1200}
+	energies := map[string]float64{"": 2500}
+
+	for _, target := range []pvoutputTarget{primary, other, primary, other} {
+		if err := postStatus(target, r, energies); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if len(*forms) != 2 {
+		t.Fatalf("posted %d statuses, want one per system", len(*forms))
+	}
+
+	r.Date = r.Date.Add(5 * time.Minute)
+
+	if err := postStatus(other, r, energies); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(*forms) != 3 {
+		t.Errorf("posted %d statuses, want the next slot posted", len(*forms))
+	}
+}
+
+func TestPostStatusesBudget(t *testing.T) {
+	tests := []struct {
+		name   string
+		budget time.Duration
+		want   []string
+	}{
+		{"no budget", 0, []string{"1", "2"}},
+		{"budget used up by the first system", 100 * time.Millisecond, []string{"1"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useTempState(t)
+			t.Cleanup(func() { outputDeadline = time.Time{} })
+
+			var posted []string
+
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				posted = append(posted, r.Header.Get("X-Pvoutput-SystemId"))
+				time.Sleep(150 * time.Millisecond)
+				w.Write([]byte("OK 200: Added Status"))
+			}))
+			defer srv.Close()
+
+			opts.PVOutputURL = srv.URL
+			opts.PVOutputVersion = "r2"
+			opts.PVOutputMethod = "POST"
+			opts.OutputBudget = tt.budget
+			outputClient = newHTTPClient(time.Second, 0, nil, false, nil)
+
+			other := testTarget()
+			other.SystemID = "2"
+			other.Name = "PVOutput system 2"
+
+			startOutputBudget(opts.OutputBudget)
+
+			errs := postStatuses([]pvoutputTarget{testTarget(), other}, Reading{Date: time.Now(), Power: 1200}, nil)
+
+			if len(errs) > 0 {
+				t.Fatal(errs)
+			}
+
+			if !reflect.DeepEqual(posted, tt.want) {
+				t.Errorf("posted to systems %v, want %v", posted, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// TimeSeriesError is returned when the time-series endpoint rejects a write.
+type TimeSeriesError struct {
+	StatusCode int
+	Body       string
+}
+
+func (e *TimeSeriesError) Error() string {
+	return fmt.Sprintf("time-series write failed: %d %s", e.StatusCode, e.Body)
+}
+
+// Retryable reports whether the failure is likely to be transient.
+func (e *TimeSeriesError) Retryable() bool {
+	return e.StatusCode == http.StatusTooManyRequests || e.StatusCode >= 500
+}
+
+// postTimeSeries sends the reading as a JSON object to the configured
+// time-series endpoint, retrying transient failures.
+func postTimeSeries(r Reading) error {
+	body, err := json.Marshal(jsonPayload(r))
+
+	if err != nil {
+		return fmt.Errorf("failed to encode time-series payload: %w", err)
+	}
+
+	backoff := time.Second
+
+	for attempt := 0; ; attempt++ {
+		err = writeTimeSeries(body)
+
+		if err == nil {
+			return nil
+		}
+
+		if tsErr, ok := err.(*TimeSeriesError); ok && !tsErr.Retryable() {
+			return err
+		}
+
+		if attempt >= opts.TSRetries {
+			return err
+		}
+
+		if outputBudgetExceeded(backoff) {
+			log.Printf("Warning: not retrying the time-series write, the output budget would run out")
+			return err
+		}
+
+		log.Printf("Warning: %v, retrying in %v", err, backoff)
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// jsonPayload returns the reading as the JSON object sent to outputs, with
+// keys renamed by --field-name.
+func jsonPayload(r Reading) map[string]any {
+	payload := map[string]any{
+		fieldName("timestamp"): r.Date.Format(time.RFC3339),
+		fieldName("system_id"): opts.SystemID,
+		fieldName("power"):     r.Power,
+		fieldName("energy"):    r.wattHours(),
+	}
+
+	if r.Voltage > 0 {
+		payload[fieldName("voltage")] = r.Voltage
+	}
+
+	for _, name := range r.extraNames() {
+		payload[fieldName(name)] = r.Extra[name]
+	}
+
+	return payload
+}
+
+// validateStatusCodes checks a comma-separated list of HTTP status codes and
+// classes such as "2xx".
+func validateStatusCodes(list string) error {
+	for _, s := range strings.Split(list, ",") {
+		s = strings.ToLower(strings.TrimSpace(s))
+
+		if len(s) == 3 && s[1:] == "xx" && s[0] >= '1' && s[0] <= '5' {
+			continue
+		}
+
+		if code, err := strconv.Atoi(s); err != nil || code < 100 || code > 599 {
+			return fmt.Errorf("%q is not a status code or class", s)
+		}
+	}
+
+	return nil
+}
+
+// statusMatches reports whether code is in a list checked by
+// validateStatusCodes.
+func statusMatches(list string, code int) bool {
+	for _, s := range strings.Split(list, ",") {
+		s = strings.ToLower(strings.TrimSpace(s))
+
+		if s == strconv.Itoa(code) || s == fmt.Sprintf("%dxx", code/100) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func writeTimeSeries(body []byte) error {
+	req, err := http.NewRequest("POST", opts.TSURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+
+	if opts.TSToken != "" {
+		if opts.TSAuthHeader == "Authorization" {
+			req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", opts.TSToken))
+		} else {
+			req.Header.Set(opts.TSAuthHeader, opts.TSToken)
+		}
+	}
+
+	resp, err := outputClient.Do(req)
+
+	if err != nil {
+		return err
+	}
+
+	defer closeBody(resp.Body)
+
+	if !statusMatches(opts.TSSuccessCodes, resp.StatusCode) {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return &TimeSeriesError{StatusCode: resp.StatusCode, Body: string(bytes.TrimSpace(msg))}
+	}
+
+	return nil
+}
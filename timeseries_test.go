@@ -0,0 +1,75 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestValidateStatusCodes(t *testing.T) {
+	tests := []struct {
+		list    string
+		wantErr bool
+	}{
+		{"2xx", false},
+		{"200, 202,204", false},
+		{"2XX,409", false},
+		{"", true},
+		{"6xx", true},
+		{"99", true},
+		{"600", true},
+		{"ok", true},
+		{"2xx,", true},
+	}
+
+	for _, tt := range tests {
+		if err := validateStatusCodes(tt.list); (err != nil) != tt.wantErr {
+			t.Errorf("validateStatusCodes(%q) = %v, want error %v", tt.list, err, tt.wantErr)
+		}
+	}
+}
+
+func TestWriteTimeSeriesSuccessCodes(t *testing.T) {
+	tests := []struct {
+		codes     string
+		status    int
+		wantErr   bool
+		retryable bool
+	}{
+		{"2xx", http.StatusNoContent, false, false},
+		{"2xx", http.StatusConflict, true, false},
+		{"2xx,409", http.StatusConflict, false, false},
+		{"200", http.StatusAccepted, true, false},
+		{"200, 202", http.StatusAccepted, false, false},
+		{"2xx", http.StatusServiceUnavailable, true, true},
+		{"2xx", http.StatusTooManyRequests, true, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.codes+"/"+http.StatusText(tt.status), func(t *testing.T) {
+			saved := opts
+			t.Cleanup(func() { opts = saved })
+
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tt.status)
+			}))
+			defer srv.Close()
+
+			opts = Options{TSURL: srv.URL, TSSuccessCodes: tt.codes}
+			outputClient = newHTTPClient(time.Second, 0, nil, false, nil)
+
+			err := writeTimeSeries([]byte(`{}`))
+
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("writeTimeSeries() = %v, want error %v", err, tt.wantErr)
+			}
+
+			var tsErr *TimeSeriesError
+			if err != nil && (!errors.As(err, &tsErr) || tsErr.Retryable() != tt.retryable) {
+				t.Errorf("writeTimeSeries() = %#v, want retryable %v", err, tt.retryable)
+			}
+		})
+	}
+}
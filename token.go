@@ -0,0 +1,88 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// tokenExpiry returns the exp claim of an Envoy JWT. The signature is not
+// verified; the Envoy remains the authority on whether a token is valid.
+func tokenExpiry(token string) (time.Time, error) {
+	parts := strings.Split(token, ".")
+
+	if len(parts) != 3 {
+		return time.Time{}, fmt.Errorf("token is not a JWT")
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(parts[1], "="))
+
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to decode token payload: %w", err)
+	}
+
+	var claims struct {
+		Exp int64 `json:"exp"`
+	}
+
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return time.Time{}, fmt.Errorf("failed to parse token claims: %w", err)
+	}
+
+	if claims.Exp == 0 {
+		return time.Time{}, fmt.Errorf("token has no expiry")
+	}
+
+	return time.Unix(claims.Exp, 0), nil
+}
+
+// probeToken makes a single authenticated request to the Envoy and reports
+// whether the token was accepted and when it expires.
+func probeToken() error {
+	if exp, err := tokenExpiry(opts.Token); err != nil {
+		fmt.Printf("Expiry:  unknown (%v)\n", err)
+	} else if until := time.Until(exp); until > 0 {
+		fmt.Printf("Expiry:  %s (in %s)\n", exp.Format(time.RFC1123), humanDuration(until))
+	} else {
+		fmt.Printf("Expiry:  %s (expired %s ago)\n", exp.Format(time.RFC1123), humanDuration(-until))
+	}
+
+	var readings EnvoyResponse
+
+	if err := envoyGet("/production.json", &readings); err != nil {
+		fmt.Println("Result:  rejected")
+		return err
+	}
+
+	fmt.Println("Result:  accepted")
+
+	return nil
+}
+
+// checkTokenExpiry refuses to continue with a token that has expired or will
+// expire within margin, rather than letting every request fail with a 401.
+func checkTokenExpiry(now time.Time, margin time.Duration) error {
+	exp, err := tokenExpiry(opts.Token)
+
+	if err != nil {
+		return fmt.Errorf("can't check the token's expiry: %w", err)
+	}
+
+	if until := exp.Sub(now); until <= 0 {
+		return fmt.Errorf("the Envoy token expired %s ago (%s), generate a new one at https://entrez.enphaseenergy.com", humanDuration(-until), exp.Format(time.RFC1123))
+	} else if until < margin {
+		return fmt.Errorf("the Envoy token expires in %s (%s), generate a new one at https://entrez.enphaseenergy.com", humanDuration(until), exp.Format(time.RFC1123))
+	}
+
+	return nil
+}
+
+func humanDuration(d time.Duration) string {
+	if d >= 48*time.Hour {
+		return fmt.Sprintf("%d days", int(d.Hours()/24))
+	}
+
+	return d.Round(time.Minute).String()
+}